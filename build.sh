@@ -1,2 +1,2 @@
 #!/usr/bin/env bash
-go build -o gtumbler-mixer cmd/mixer/main.go && go build -o gtumbler-client cmd/client/main.go
+go build -o gtumbler-mixer cmd/mixer/main.go && go build -o gtumbler-client cmd/client/main.go && go build -o gtumbler-jobcoind cmd/jobcoind/main.go
@@ -3,6 +3,7 @@ package main
 import (
 	"fmt"
 	"github.com/Denton24646/gtumbler/pkg/client"
+	"github.com/Denton24646/gtumbler/pkg/crypto"
 	"github.com/crgimenes/goconfig"
 	"log"
 	"time"
@@ -16,9 +17,20 @@ func main() {
 		log.Fatalf("parsing config: %s", err)
 	}
 
+	size, err := crypto.ParseAmount(config.Size)
+	if err != nil {
+		log.Fatalf("parsing size: %s", err)
+	}
+	percentages, err := client.ParsePercentages(config.Percentages)
+	if err != nil {
+		log.Fatalf("parsing percentages: %s", err)
+	}
+
 	// setup user client
 	fmt.Println("**** Welcome to the gtumber client ****")
-	c := client.New(config)
+	c := client.New(config, crypto.NewRetryLedger(crypto.NewJobCoin(config.LedgerURL)))
+	c.Size = size
+	c.Percentages = percentages
 
 	// create addresses or use addresses provided
 	fmt.Println("**** Generating newly created address for use with the gtumbler mixer")
@@ -30,28 +42,54 @@ func main() {
 
 	err = c.SendCleanAddresses()
 	if err != nil {
-		log.Printf("\nerror receiving deposit address: %s", err)
+		log.Fatalf("error receiving deposit address: %s", err)
 	}
 	fmt.Printf("**** gtumbler deposit address %s\n", c.DepositAddress)
-	fmt.Printf("**** Sending amount %s to deposit address from address %s\n", config.Size, config.SendAddress)
+	fmt.Printf("**** gtumbler fee %.4f%% of the deposit plus %s, at least %s: %s for this deposit\n",
+		c.Fee.Rate*100, c.Fee.Flat, c.Fee.Minimum, c.Fee.Estimate)
+	fmt.Printf("**** gtumbler will spread the payout over up to %s once the deposit arrives\n", c.MaxDelay)
+	fmt.Printf("**** Sending amount %s to deposit address from address %s\n", size, config.SendAddress)
 
-	err = c.SendDeposit(config.SendAddress, config.Size)
+	err = c.SendDeposit(config.SendAddress, size)
 	if err != nil {
 		log.Printf(" error sending funds to deposit address %s: %s", c.DepositAddress, err)
 	}
 	fmt.Println("**** Deposit sent to gtumbler mixer ****")
-	fmt.Println("**** Waiting 5 seconds and checking blockchain for mixing status ****")
+	fmt.Println("**** Waiting 5 seconds and checking the mixer for mixing status ****")
 
 	for {
-		finished, err := c.CheckCleanAddresses()
+		status, err := c.CheckStatus()
 		if err != nil {
-			fmt.Println("**** Issue mixing your coins. Check the blockchain for more information. ****")
+			fmt.Printf("**** Issue checking the status of your coins: %s ****\n", err)
+			time.Sleep(5 * time.Second)
+			continue
+		}
+		fmt.Printf("**** Status %s: received %s, paid out %s ****\n", status.State, status.Received, status.PaidOut)
+		if status.NextSend != nil {
+			fmt.Printf("**** Next transfer scheduled at %s ****\n", status.NextSend.Format(time.RFC3339))
 		}
-		if finished {
+
+		switch status.State {
+		case "completed":
+			for address, amount := range status.Payouts {
+				fmt.Printf("  Address %s: %s\n", address, amount)
+			}
 			fmt.Println("**** Successful mixing. The coins are now in the addresses specified. Thank you for using gtumbler. ****")
-			break
-		} else {
-			time.Sleep(5 * time.Second)
+			return
+		case "failed", "refunded", "cancelled":
+			// the move into refunded carries no reason of its own, the latest state change that does explains it
+			for i := len(status.History) - 1; i >= 0; i-- {
+				if status.History[i].Reason != "" {
+					fmt.Printf("**** Reason: %s ****\n", status.History[i].Reason)
+					break
+				}
+			}
+			for _, refund := range status.Refunds {
+				fmt.Printf("  Refund of %s to %s (sent: %t): %s\n", refund.Amount, refund.To, refund.Sent, refund.Reason)
+			}
+			fmt.Println("**** Issue mixing your coins. Check the blockchain for more information. ****")
+			return
 		}
+		time.Sleep(5 * time.Second)
 	}
 }
@@ -0,0 +1,40 @@
+package main
+
+import (
+	"github.com/Denton24646/gtumbler/pkg/crypto"
+	"github.com/Denton24646/gtumbler/pkg/jobcoin"
+	"github.com/crgimenes/goconfig"
+	"log"
+	"net/http"
+	"os"
+)
+
+type config struct {
+	Listen   string `cfgDefault:":8990"`
+	SeedFile string `cfgDefault:"jobcoind-seed.json"`
+}
+
+func main() {
+	// get configuration from the command line or the environment
+	cfg := config{}
+	err := goconfig.Parse(&cfg)
+	if err != nil {
+		log.Fatalf("parsing config: %s", err)
+	}
+
+	log.Print("**** Starting local jobcoin service ****")
+	ledger := crypto.NewMemoryLedger()
+
+	seed, err := jobcoin.LoadSeed(cfg.SeedFile)
+	if err != nil && !os.IsNotExist(err) {
+		log.Fatalf("loading seed file %s: %s", cfg.SeedFile, err)
+	}
+	err = seed.Apply(ledger)
+	if err != nil {
+		log.Fatalf("seeding ledger: %s", err)
+	}
+	log.Printf("**** Seeded %d addresses from %s ****", len(seed), cfg.SeedFile)
+
+	log.Printf("**** Listening on %s, point the mixer and client at http://localhost%s/api ****", cfg.Listen, cfg.Listen)
+	log.Fatal(http.ListenAndServe(cfg.Listen, jobcoin.New(ledger)))
+}
@@ -1,16 +1,96 @@
 package main
 
 import (
+	"context"
+	"github.com/Denton24646/gtumbler/pkg/crypto"
 	"github.com/Denton24646/gtumbler/pkg/mixer"
+	"github.com/Denton24646/gtumbler/pkg/store"
+	"github.com/crgimenes/goconfig"
 	"log"
 	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
 )
 
 func main() {
 	log.Print("**** Starting gtumbler mixer service ****")
-	m := mixer.New()
+	// get configuration from gtumbler-mixer.json (or the file in $MIXERCONFIG), the environment and the command line,
+	// in increasing order of precedence
+	configFile := "gtumbler-mixer.json"
+	if file := os.Getenv("MIXERCONFIG"); file != "" {
+		configFile = file
+	}
+	config, err := mixer.LoadConfig(configFile)
+	if err != nil && !os.IsNotExist(err) {
+		log.Fatalf("loading config file %s: %s", configFile, err)
+	}
+	// config already holds the defaults, goconfig only applies the environment and the command line on top of it:
+	// with its default tag it would also put back the default of every zero the file sets
+	goconfig.TagDefault = "cfgNone"
+	err = goconfig.Parse(&config)
+	if err != nil {
+		log.Fatalf("parsing config: %s", err)
+	}
 
-	http.HandleFunc("/create", m.Create)
-	log.Print("**** Listening on port 8989 for new mixer deposit transactions ****")
-	log.Fatal(http.ListenAndServe(":8989", nil))
+	s, err := store.NewFile(config.StorePath)
+	if err != nil {
+		log.Fatalf("opening store %s: %s", config.StorePath, err)
+	}
+	// retry ledger calls that fail on the way, without ever sending the same coins twice
+	m := mixer.New(crypto.NewRetryLedger(crypto.NewJobCoin(config.LedgerURL)), s)
+	err = m.Configure(config)
+	if err != nil {
+		log.Fatalf("configuring mixer: %s", err)
+	}
+
+	resumed, err := m.Resume()
+	if err != nil {
+		log.Fatalf("resuming customers from %s: %s", config.StorePath, err)
+	}
+	log.Printf("**** Resumed %d unfinished customers from %s ****", len(resumed), config.StorePath)
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/create", m.Create)
+	mux.HandleFunc("/status/", m.Status)
+	mux.HandleFunc("/cancel/", m.Cancel)
+	mux.HandleFunc("/fees", m.Fees)
+	mux.HandleFunc("/rounds", m.Rounds)
+	mux.HandleFunc("/houses", m.Houses)
+	mux.HandleFunc("/liquidity", m.Liquidity)
+
+	// refund anything sent to jobs that no longer accept deposits
+	m.Go(func(ctx context.Context) { m.WatchDeposits(ctx, m.WatchInterval) })
+	// move what is left in retired house addresses into active ones
+	m.Go(func(ctx context.Context) { m.WatchHouses(ctx, m.SweepInterval) })
+	// keep the house addresses evenly funded
+	m.Go(func(ctx context.Context) { m.WatchLiquidity(ctx, m.RebalanceInterval) })
+
+	server := &http.Server{Addr: config.Listen, Handler: mux}
+	go func() {
+		log.Printf("**** Listening on %s for new mixer deposit transactions ****", config.Listen)
+		err := server.ListenAndServe()
+		if err != http.ErrServerClosed {
+			log.Fatal(err)
+		}
+	}()
+
+	// stop cleanly on ctrl-c or SIGTERM, a second signal kills the mixer right away
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	<-ctx.Done()
+	stop()
+	log.Printf("**** Shutting down, waiting up to %s for running jobs to stop ****", m.ShutdownTimeout)
+
+	ctx, cancel := context.WithTimeout(context.Background(), m.ShutdownTimeout)
+	defer cancel()
+	// /create turns new jobs away while the running ones stop, /status keeps answering until they did
+	unfinished, err := m.Shutdown(ctx)
+	if err != nil {
+		log.Printf("error waiting for jobs to stop: %s", err)
+	}
+	err = server.Shutdown(ctx)
+	if err != nil {
+		log.Printf("error stopping the http server: %s", err)
+	}
+	log.Printf("**** Stopped with %d unfinished customers %v, they resume on the next start ****", len(unfinished), unfinished)
 }
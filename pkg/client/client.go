@@ -2,6 +2,7 @@ package client
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"fmt"
 	"github.com/Denton24646/gtumbler/pkg/crypto"
@@ -16,34 +17,63 @@ import (
 // 1. The initial request sends a list of new addresses that the mixed coins will eventually be sent back to
 // 2. The mixer responds with a deposit address
 // 3. The client sends the full deposit amount to the deposit address
-// 4. From that point on the client checks the list of addresses sent in (1) to be notified when their mixing coins are available
+// 4. From that point on the client polls the mixer status endpoint to be notified when their mixing coins are available
+
+// DefaultTimeout bounds a single request to the mixer, a context can cut it shorter
+const DefaultTimeout = 30 * time.Second
 
 type Client interface {
 	CreateCleanAddresses(number int) ([]crypto.Address, error)
 	SendCleanAddresses() error
-	SendDeposit(address crypto.Address) error
+	SendCleanAddressesContext(ctx context.Context) error
+	SendDeposit(address crypto.Address, size crypto.Amount) error
+	SendDepositContext(ctx context.Context, address crypto.Address, size crypto.Amount) error
 	CheckCleanAddresses() (bool, error)
+	CheckCleanAddressesContext(ctx context.Context) (bool, error)
+	CheckStatus() (*models.StatusResponse, error)
+	CheckStatusContext(ctx context.Context) (*models.StatusResponse, error)
 }
 
+// UserClient is the Client the command line client runs
+var _ Client = (*UserClient)(nil)
+
 type UserClient struct {
 	// Id is pseudo-random id shared between client and server
 	Id int
 	// mixerURL is the location of the mixer server (localhost:8989 when running locally)
 	mixerURL string
+	// statusURL is the location of the mixer status endpoint, the client id is appended to it
+	statusURL string
 	// List of clean addresses the client wants the coins to end up in: these can be generated or provided at runtime
 	CleanAddresses []crypto.Address
+	// Percentages is the share of the payout each clean address should get, when nil the mixer picks random shares
+	Percentages []float64
 	// Deposit address that the user client receives from the server
 	DepositAddress crypto.Address
+	// Size is how much the client plans to deposit, it is sent to the mixer to get an accurate fee quote
+	Size crypto.Amount
+	// MaxDelay is the longest the client is willing to wait for the payout, the mixer spreads its sends over that time
+	MaxDelay time.Duration
+	// Fee is the fee the mixer will charge, quoted by the server along with the deposit address
+	Fee models.FeeQuote
 	// Timestamp of when the client deposit was sent
 	SentTimestamp time.Time
 	// Timestamp of when the funds were deposited across all customer addresses (the mixing is complete)
 	ReceivedTimestamp time.Time
+	// ledger is the coin network the client sends its deposit on and watches its clean addresses with
+	ledger crypto.Ledger
+	// client sends the requests to the mixer, its timeout keeps a hanging mixer from blocking the client forever
+	client *http.Client
 }
 
-func New(config Config) *UserClient {
+func New(config Config, ledger crypto.Ledger) *UserClient {
 	return &UserClient{
-		Id:       rand.Int(),
-		mixerURL: config.MixerURL,
+		Id:        rand.Int(),
+		mixerURL:  config.MixerURL,
+		statusURL: config.StatusURL,
+		MaxDelay:  time.Duration(config.MaxDelay) * time.Second,
+		ledger:    ledger,
+		client:    &http.Client{Timeout: DefaultTimeout},
 	}
 }
 
@@ -52,7 +82,7 @@ func New(config Config) *UserClient {
 func (u *UserClient) CreateCleanAddresses(number int) ([]crypto.Address, error) {
 	var addresses []crypto.Address
 	for i := 0; i < number; i++ {
-		a, err := crypto.CreateAddress()
+		a, err := u.ledger.CreateAddress()
 		if err != nil {
 			return nil, err
 		}
@@ -66,17 +96,31 @@ func (u *UserClient) CreateCleanAddresses(number int) ([]crypto.Address, error)
 // SendCleanAddresses sends the clean addresses to the mixer in an http POST request to the specified endpoint
 // The mixer sends the deposit address in the response to the request
 func (u *UserClient) SendCleanAddresses() error {
+	return u.SendCleanAddressesContext(context.Background())
+}
+
+// SendCleanAddressesContext is SendCleanAddresses, giving up once ctx is done
+func (u *UserClient) SendCleanAddressesContext(ctx context.Context) error {
+	maxDelay := int64(u.MaxDelay / time.Second)
 	request := models.CleanAddressRequest{
-		Id:        u.Id,
-		Addresses: u.CleanAddresses,
+		Id:          u.Id,
+		Addresses:   u.CleanAddresses,
+		Percentages: u.Percentages,
+		Size:        u.Size,
+		MaxDelay:    &maxDelay,
 	}
 
-	req, err := json.Marshal(request)
+	payload, err := json.Marshal(request)
 	if err != nil {
 		return err
 	}
 
-	resp, err := http.Post(u.mixerURL, "application/json", bytes.NewBuffer(req))
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, u.mixerURL, bytes.NewBuffer(payload))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := u.client.Do(req)
 	if err != nil {
 		return err
 	}
@@ -87,18 +131,29 @@ func (u *UserClient) SendCleanAddresses() error {
 		return err
 	}
 
+	if resp.StatusCode != http.StatusOK {
+		return mixerError(resp.StatusCode, body)
+	}
+
 	response := &models.CleanAddressResponse{}
 	if err := json.Unmarshal(body, response); err != nil {
 		return err
 	}
 
 	u.DepositAddress = response.DepositAddress
+	u.Fee = response.Fee
+	u.MaxDelay = time.Duration(response.MaxDelay) * time.Second
 	return nil
 }
 
 // SendDeposit sends coins to the deposit address specified by the mixer from an arbitrary address
 func (u *UserClient) SendDeposit(address crypto.Address, size crypto.Amount) error {
-	err := crypto.Send(address, u.DepositAddress, size)
+	return u.SendDepositContext(context.Background(), address, size)
+}
+
+// SendDepositContext is SendDeposit, giving up once ctx is done
+func (u *UserClient) SendDepositContext(ctx context.Context, address crypto.Address, size crypto.Amount) error {
+	err := u.ledger.SendContext(ctx, address, u.DepositAddress, size)
 	if err != nil {
 		return err
 	}
@@ -106,23 +161,69 @@ func (u *UserClient) SendDeposit(address crypto.Address, size crypto.Amount) err
 }
 
 // CheckCleanAddresses checks to see if all the provided addresses received the end deposits from the mixer
-// It returns true in the case where at least one provided address has funds, false otherwise
-// Assumes provided addresses have a zero balance initially
+// It returns true in the case where at least one provided address received coins, false otherwise
+// Incoming transactions are used rather than the balance so the check does not depend on how the ledger formats amounts
 func (u *UserClient) CheckCleanAddresses() (bool, error) {
+	return u.CheckCleanAddressesContext(context.Background())
+}
+
+// CheckCleanAddressesContext is CheckCleanAddresses, giving up once ctx is done
+func (u *UserClient) CheckCleanAddressesContext(ctx context.Context) (bool, error) {
 	var found bool
 	for _, address := range u.CleanAddresses {
-		amount, err := crypto.CheckAddress(address)
+		response, err := u.ledger.CheckAddressContext(ctx, address)
 		if err != nil {
 			return false, err
 		}
-		if amount == crypto.Amount("0.0") {
+		if len(crypto.Incoming(response.Transactions, address)) == 0 {
 			continue
 		}
-		if amount != crypto.Amount("0.0") {
-			found = true
-			return found, nil
-		}
+		found = true
+		return found, nil
 	}
 
 	return found, nil
 }
+
+// CheckStatus asks the mixer where the client's job is: its state, how much was received and how much was paid out so far
+func (u *UserClient) CheckStatus() (*models.StatusResponse, error) {
+	return u.CheckStatusContext(context.Background())
+}
+
+// CheckStatusContext is CheckStatus, giving up once ctx is done
+func (u *UserClient) CheckStatusContext(ctx context.Context) (*models.StatusResponse, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, fmt.Sprint(u.statusURL, u.Id), nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := u.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, mixerError(resp.StatusCode, body)
+	}
+
+	response := &models.StatusResponse{}
+	if err := json.Unmarshal(body, response); err != nil {
+		return nil, err
+	}
+
+	return response, nil
+}
+
+// mixerError turns a failed mixer response into an error, the mixer sends a models.ErrorResponse for every failure
+func mixerError(status int, body []byte) error {
+	response := &models.ErrorResponse{}
+	if err := json.Unmarshal(body, response); err != nil || response.Code == "" {
+		return fmt.Errorf("mixer responded with status %d", status)
+	}
+	return response
+}
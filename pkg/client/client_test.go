@@ -0,0 +1,110 @@
+package client
+
+import (
+	"context"
+	"fmt"
+	"github.com/Denton24646/gtumbler/pkg/crypto"
+	"github.com/Denton24646/gtumbler/pkg/mixer"
+	"github.com/Denton24646/gtumbler/pkg/models"
+	"github.com/Denton24646/gtumbler/pkg/store"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// newTestMixer starts a mixer on an in-memory ledger with a funded Genesis address and five funded house addresses,
+// and returns a client pointed at it
+func newTestMixer(t *testing.T) (*httptest.Server, *mixer.Mixer, *UserClient) {
+	ledger := crypto.NewMemoryLedger()
+	for _, address := range []crypto.Address{"Genesis", "House1", "House2", "House3", "House4", "House5"} {
+		if err := ledger.Mint(address, crypto.MustParseAmount("10")); err != nil {
+			t.Fatalf("error seeding address %s: %s", address, err)
+		}
+	}
+
+	m := mixer.New(ledger, store.NewMemory())
+	m.PollInterval = 10 * time.Millisecond
+	m.Pool.Interval = 0
+	mux := http.NewServeMux()
+	mux.HandleFunc("/create", m.Create)
+	mux.HandleFunc("/status/", m.Status)
+	server := httptest.NewServer(mux)
+
+	c := New(Config{MixerURL: server.URL + "/create", StatusURL: server.URL + "/status/"}, ledger)
+	return server, m, c
+}
+
+func TestUserClient_Status(t *testing.T) {
+	server, m, c := newTestMixer(t)
+	defer server.Close()
+	defer m.Shutdown(context.Background())
+
+	if _, err := c.CreateCleanAddresses(2); err != nil {
+		t.Fatalf("error creating addresses: %s", err)
+	}
+	c.Size = crypto.MustParseAmount("1")
+	if err := c.SendCleanAddresses(); err != nil {
+		t.Fatalf("error sending clean addresses: %s", err)
+	}
+	if c.DepositAddress == "" {
+		t.Fatalf("expected the mixer to send a deposit address")
+	}
+	if err := c.SendDeposit("Genesis", c.Size); err != nil {
+		t.Fatalf("error sending deposit: %s", err)
+	}
+
+	// the status of the job makes it back to the client until the job completed
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		status, err := c.CheckStatus()
+		if err != nil {
+			t.Fatalf("error checking status: %s", err)
+		}
+		if status.Id != c.Id || status.DepositAddress != c.DepositAddress {
+			t.Fatalf("expected the status of job %d at %s, got %+v", c.Id, c.DepositAddress, status)
+		}
+		if status.State == "completed" {
+			if status.Received != c.Size || status.PaidOut != c.Size.Sub(status.FeeAmount) {
+				t.Errorf("expected %s received and paid out less the fee, got %+v", c.Size, status)
+			}
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("job did not complete, last status %+v", status)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	found, err := c.CheckCleanAddresses()
+	if err != nil || !found {
+		t.Errorf("expected the clean addresses to have received coins, got %t: %v", found, err)
+	}
+}
+
+func TestUserClient_MixerError(t *testing.T) {
+	server, m, c := newTestMixer(t)
+	defer server.Close()
+	defer m.Shutdown(context.Background())
+
+	// the error envelope of the mixer comes back as the error itself
+	err := c.SendCleanAddresses()
+	if response, ok := err.(*models.ErrorResponse); !ok || response.Code != models.ErrNoAddresses {
+		t.Errorf("expected a %s error sending no addresses, got %v", models.ErrNoAddresses, err)
+	}
+	_, err = c.CheckStatus()
+	if response, ok := err.(*models.ErrorResponse); !ok || response.Code != models.ErrNotFound {
+		t.Errorf("expected a %s error for a job that was never created, got %v", models.ErrNotFound, err)
+	}
+
+	// anything in front of the mixer that fails without the envelope is reported by its status
+	broken := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		http.Error(w, "bad gateway", http.StatusBadGateway)
+	}))
+	defer broken.Close()
+	c.statusURL = broken.URL + "/status/"
+	_, err = c.CheckStatus()
+	if _, ok := err.(*models.ErrorResponse); ok || err == nil || err.Error() != fmt.Sprintf("mixer responded with status %d", http.StatusBadGateway) {
+		t.Errorf("expected a plain error with status %d, got %v", http.StatusBadGateway, err)
+	}
+}
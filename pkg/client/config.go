@@ -1,10 +1,38 @@
 package client
 
-import "github.com/Denton24646/gtumbler/pkg/crypto"
+import (
+	"fmt"
+	"github.com/Denton24646/gtumbler/pkg/crypto"
+	"strconv"
+	"strings"
+)
 
+// Size is parsed with crypto.ParseAmount, it is kept as a string so it can be set from the environment
+// MaxDelay is the longest in seconds the client is willing to wait for the mixer to pay out once it deposited
+// Percentages optionally fixes the share of each clean address as a comma separated list, for example "50,30,20"
 type Config struct {
 	MixerURL        string         `cfgDefault:"http://localhost:8989/create"`
+	StatusURL       string         `cfgDefault:"http://localhost:8989/status/"`
+	LedgerURL       string         `cfgDefault:"http://jobcoin.gemini.com/survey/api"`
 	NumberAddresses int            `cfgDefault:"3"`
 	SendAddress     crypto.Address `cfgDefault:"Genesis"`
-	Size            crypto.Amount  `cfgDefault:"4"`
+	Size            string         `cfgDefault:"4"`
+	MaxDelay        int            `cfgDefault:"60"`
+	Percentages     string
+}
+
+// ParsePercentages parses the comma separated Percentages setting, an empty setting means random shares
+func ParsePercentages(raw string) ([]float64, error) {
+	if strings.TrimSpace(raw) == "" {
+		return nil, nil
+	}
+	var percentages []float64
+	for _, field := range strings.Split(raw, ",") {
+		percentage, err := strconv.ParseFloat(strings.TrimSpace(field), 64)
+		if err != nil {
+			return nil, fmt.Errorf("percentage %q: %s", field, err)
+		}
+		percentages = append(percentages, percentage)
+	}
+	return percentages, nil
 }
@@ -0,0 +1,175 @@
+package crypto
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"strconv"
+	"strings"
+)
+
+// Precision is the number of decimal places an Amount keeps track of
+const Precision = 8
+
+// unitsPerCoin is the number of indivisible units in a single coin (10^Precision)
+const unitsPerCoin = 100000000
+
+// Amount is an exact fixed-point decimal number of coins
+// Internally it counts indivisible units of 10^-8 coins so adding, subtracting and splitting amounts never loses precision
+// On the wire it is rendered as a decimal string such as "1.25", the same way JobCoin represents amounts
+type Amount struct {
+	units int64
+}
+
+// Zero is an amount of no coins
+var Zero = Amount{}
+
+// ParseAmount parses a decimal string such as "4", "0.5" or "1.25000000" into an Amount
+// It fails for amounts with more than Precision decimal places instead of silently rounding them
+func ParseAmount(s string) (Amount, error) {
+	raw := strings.TrimSpace(s)
+	negative := strings.HasPrefix(raw, "-")
+	raw = strings.TrimPrefix(raw, "-")
+
+	whole, fraction := raw, ""
+	if i := strings.IndexByte(raw, '.'); i >= 0 {
+		whole, fraction = raw[:i], raw[i+1:]
+	}
+	if whole == "" && fraction == "" {
+		return Zero, fmt.Errorf("invalid amount %q", s)
+	}
+	if !digits(whole) || !digits(fraction) {
+		return Zero, fmt.Errorf("invalid amount %q", s)
+	}
+
+	fraction = strings.TrimRight(fraction, "0")
+	if len(fraction) > Precision {
+		return Zero, fmt.Errorf("invalid amount %q: more than %d decimal places", s, Precision)
+	}
+	fraction += strings.Repeat("0", Precision-len(fraction))
+
+	units, err := strconv.ParseInt(strings.TrimLeft(whole, "0")+fraction, 10, 64)
+	if err != nil {
+		return Zero, fmt.Errorf("invalid amount %q: out of range", s)
+	}
+	if negative {
+		units = -units
+	}
+
+	return Amount{units: units}, nil
+}
+
+// MustParseAmount is like ParseAmount but panics on invalid input, it is meant for constants and tests
+func MustParseAmount(s string) Amount {
+	amount, err := ParseAmount(s)
+	if err != nil {
+		panic(err)
+	}
+	return amount
+}
+
+// AmountFromUnits returns the Amount made of units indivisible units of 10^-8 coins
+func AmountFromUnits(units int64) Amount {
+	return Amount{units: units}
+}
+
+// Units returns the number of indivisible units of 10^-8 coins in the amount
+func (a Amount) Units() int64 {
+	return a.units
+}
+
+// String renders the amount as a decimal without trailing zeros, for example "1.25" or "4"
+func (a Amount) String() string {
+	units := a.units
+	sign := ""
+	if units < 0 {
+		sign = "-"
+		units = -units
+	}
+
+	whole := units / unitsPerCoin
+	fraction := strings.TrimRight(fmt.Sprintf("%0*d", Precision, units%unitsPerCoin), "0")
+	if fraction == "" {
+		return fmt.Sprintf("%s%d", sign, whole)
+	}
+	return fmt.Sprintf("%s%d.%s", sign, whole, fraction)
+}
+
+// Add returns a + b
+func (a Amount) Add(b Amount) Amount {
+	return Amount{units: a.units + b.units}
+}
+
+// Sub returns a - b
+func (a Amount) Sub(b Amount) Amount {
+	return Amount{units: a.units - b.units}
+}
+
+// MulRatio returns a * numerator / denominator rounded down to the nearest unit
+// Intermediate results are computed with arbitrary precision so large amounts do not overflow
+func (a Amount) MulRatio(numerator int64, denominator int64) Amount {
+	if denominator == 0 {
+		panic("crypto: MulRatio with zero denominator")
+	}
+	result := new(big.Int).Mul(big.NewInt(a.units), big.NewInt(numerator))
+	result.Quo(result, big.NewInt(denominator))
+	return Amount{units: result.Int64()}
+}
+
+// Cmp compares a and b and returns -1 if a < b, 0 if a == b and +1 if a > b
+func (a Amount) Cmp(b Amount) int {
+	switch {
+	case a.units < b.units:
+		return -1
+	case a.units > b.units:
+		return 1
+	}
+	return 0
+}
+
+// IsZero reports whether the amount is exactly zero
+func (a Amount) IsZero() bool {
+	return a.units == 0
+}
+
+// IsPositive reports whether the amount is greater than zero
+func (a Amount) IsPositive() bool {
+	return a.units > 0
+}
+
+// MarshalJSON renders the amount as a json string, for example "1.25"
+func (a Amount) MarshalJSON() ([]byte, error) {
+	return json.Marshal(a.String())
+}
+
+// UnmarshalJSON accepts both json strings ("1.25") and json numbers (1.25)
+func (a *Amount) UnmarshalJSON(data []byte) error {
+	raw := string(bytes.TrimSpace(data))
+	if raw == "null" {
+		return nil
+	}
+	if strings.HasPrefix(raw, `"`) {
+		err := json.Unmarshal(data, &raw)
+		if err != nil {
+			return err
+		}
+	}
+
+	amount, err := ParseAmount(raw)
+	if err != nil {
+		return err
+	}
+	*a = amount
+	return nil
+}
+
+// digits reports whether s only contains the characters 0-9
+func digits(s string) bool {
+	for _, c := range s {
+		if c < '0' || c > '9' {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,86 @@
+package crypto
+
+import (
+	"encoding/json"
+	"fmt"
+	"testing"
+)
+
+func TestAmount_Parse(t *testing.T) {
+	tableTests := []struct {
+		input  string
+		units  int64
+		output string
+		valid  bool
+	}{
+		{"4", 400000000, "4", true},
+		{"0.5", 50000000, "0.5", true},
+		{"1.25000000", 125000000, "1.25", true},
+		{".1", 10000000, "0.1", true},
+		{"0.00000001", 1, "0.00000001", true},
+		{"-2.5", -250000000, "-2.5", true},
+		{"0.000000001", 0, "", false},
+		{"1e5", 0, "", false},
+		{"abc", 0, "", false},
+		{"", 0, "", false},
+	}
+
+	for i, tt := range tableTests {
+		t.Run(fmt.Sprintf("test %d", i), func(t *testing.T) {
+			amount, err := ParseAmount(tt.input)
+			if (err == nil) != tt.valid {
+				t.Fatalf("record %d got error %v, want valid %t", i, err, tt.valid)
+			}
+			if !tt.valid {
+				return
+			}
+			if amount.Units() != tt.units {
+				t.Errorf("record %d got %d units, want %d", i, amount.Units(), tt.units)
+			}
+			if amount.String() != tt.output {
+				t.Errorf("record %d got %s, want %s", i, amount, tt.output)
+			}
+		})
+	}
+}
+
+func TestAmount_Arithmetic(t *testing.T) {
+	a := MustParseAmount("1.1")
+	b := MustParseAmount("2.2")
+
+	if sum := a.Add(b); sum != MustParseAmount("3.3") {
+		t.Errorf("expected 1.1 + 2.2 = 3.3, got %s", sum)
+	}
+	if diff := b.Sub(a); diff != MustParseAmount("1.1") {
+		t.Errorf("expected 2.2 - 1.1 = 1.1, got %s", diff)
+	}
+	if third := MustParseAmount("1").MulRatio(1, 3); third != MustParseAmount("0.33333333") {
+		t.Errorf("expected 1 * 1/3 = 0.33333333, got %s", third)
+	}
+	if a.Cmp(b) != -1 || b.Cmp(a) != 1 || a.Cmp(a) != 0 {
+		t.Errorf("unexpected comparison between %s and %s", a, b)
+	}
+}
+
+func TestAmount_JSON(t *testing.T) {
+	request := SendCoinRequest{From: "Genesis", To: "Alice", Amount: MustParseAmount("1.5")}
+	res, err := json.Marshal(request)
+	if err != nil {
+		t.Fatalf("error marshalling request: %s", err)
+	}
+	expected := `{"fromAddress":"Genesis","toAddress":"Alice","amount":"1.5"}`
+	if string(res) != expected {
+		t.Errorf("expected %s got %s", expected, res)
+	}
+
+	// JobCoin renders amounts as strings but numbers are accepted too
+	for _, body := range []string{`{"balance":"2.75"}`, `{"balance":2.75}`} {
+		response := &CheckAddressResponse{}
+		if err := json.Unmarshal([]byte(body), response); err != nil {
+			t.Fatalf("error unmarshalling %s: %s", body, err)
+		}
+		if response.Balance != MustParseAmount("2.75") {
+			t.Errorf("expected balance 2.75 from %s, got %s", body, response.Balance)
+		}
+	}
+}
@@ -0,0 +1,160 @@
+package crypto
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// JobCoinURL is the location of the public JobCoin API
+const JobCoinURL = "http://jobcoin.gemini.com/survey/api"
+
+// DefaultTimeout bounds a single request to the JobCoin API, a context can cut it shorter
+const DefaultTimeout = 30 * time.Second
+
+const (
+	transactionsPath = "/transactions"
+	addressesPath    = "/addresses/"
+)
+
+// JobCoin is a Ledger backed by the JobCoin http API
+type JobCoin struct {
+	// url is the base location of the API, for example http://jobcoin.gemini.com/survey/api
+	url string
+	// client sends the requests, its timeout keeps a hanging API from blocking the caller forever
+	client *http.Client
+}
+
+func NewJobCoin(url string) *JobCoin {
+	return &JobCoin{
+		url:    strings.TrimSuffix(url, "/"),
+		client: &http.Client{Timeout: DefaultTimeout},
+	}
+}
+
+// Send physically sends coins from "from" to "to" over the protocol
+func (j *JobCoin) Send(from Address, to Address, size Amount) error {
+	return j.SendContext(context.Background(), from, to, size)
+}
+
+// SendContext sends coins from "from" to "to" over the protocol, giving up once ctx is done
+func (j *JobCoin) SendContext(ctx context.Context, from Address, to Address, size Amount) error {
+	request := &SendCoinRequest{
+		From:   from,
+		To:     to,
+		Amount: size,
+	}
+
+	body, err := json.Marshal(request)
+	if err != nil {
+		return err
+	}
+
+	target := j.url + transactionsPath
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, target, bytes.NewBuffer(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := j.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusUnprocessableEntity {
+		return &InsufficientFundsError{Address: from}
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		return &StatusError{Status: resp.StatusCode, Target: target}
+	}
+
+	return nil
+}
+
+// Balance checks the balance of an address and returns the number of coins held, which is at-least 0
+// Sent over the protocol
+func (j *JobCoin) Balance(address Address) (Amount, error) {
+	return j.BalanceContext(context.Background(), address)
+}
+
+// BalanceContext is Balance, giving up once ctx is done
+func (j *JobCoin) BalanceContext(ctx context.Context, address Address) (Amount, error) {
+	result, err := j.CheckAddressContext(ctx, address)
+	if err != nil {
+		return Zero, err
+	}
+
+	return result.Balance, nil
+}
+
+// CheckAddress fetches the balance and transaction history of an address
+// Sent over the protocol
+func (j *JobCoin) CheckAddress(address Address) (*CheckAddressResponse, error) {
+	return j.CheckAddressContext(context.Background(), address)
+}
+
+// CheckAddressContext is CheckAddress, giving up once ctx is done
+func (j *JobCoin) CheckAddressContext(ctx context.Context, address Address) (*CheckAddressResponse, error) {
+	target := fmt.Sprint(j.url, addressesPath, address)
+	result := &CheckAddressResponse{}
+
+	err := j.get(ctx, target, result)
+	if err != nil {
+		return nil, err
+	}
+
+	return result, nil
+}
+
+// Transactions returns every transaction recorded by JobCoin
+func (j *JobCoin) Transactions() ([]Transaction, error) {
+	return j.TransactionsContext(context.Background())
+}
+
+// TransactionsContext is Transactions, giving up once ctx is done
+func (j *JobCoin) TransactionsContext(ctx context.Context) ([]Transaction, error) {
+	var result []Transaction
+
+	err := j.get(ctx, j.url+transactionsPath, &result)
+	if err != nil {
+		return nil, err
+	}
+
+	return result, nil
+}
+
+// CreateAddress generates a new address - JobCoin accepts any string as an address so it is generated locally
+func (j *JobCoin) CreateAddress() (Address, error) {
+	return CreateAddress()
+}
+
+// get fetches target and decodes the json body into result
+func (j *JobCoin) get(ctx context.Context, target string, result interface{}) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, target, nil)
+	if err != nil {
+		return err
+	}
+	resp, err := j.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return &StatusError{Status: resp.StatusCode, Target: target}
+	}
+
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+
+	return json.Unmarshal(body, result)
+}
@@ -0,0 +1,37 @@
+package crypto
+
+import (
+	"context"
+	"fmt"
+)
+
+// Ledger is the set of operations gtumbler needs from the underlying coin network
+// The mixer, tumbler and client receive a Ledger instead of talking to a specific backend directly,
+// which allows them to run against JobCoin, a local stand-in or an in-process ledger
+// Every call that goes out to the network has a Context variant that gives up once ctx is done, the plain call
+// is the same as the Context variant with context.Background()
+type Ledger interface {
+	// Send physically sends coins from "from" to "to"
+	Send(from Address, to Address, size Amount) error
+	SendContext(ctx context.Context, from Address, to Address, size Amount) error
+	// Balance returns the number of coins held by an address, which is at-least 0
+	Balance(address Address) (Amount, error)
+	BalanceContext(ctx context.Context, address Address) (Amount, error)
+	// CheckAddress returns the balance of an address along with every transaction sent to or from it
+	CheckAddress(address Address) (*CheckAddressResponse, error)
+	CheckAddressContext(ctx context.Context, address Address) (*CheckAddressResponse, error)
+	// Transactions returns the history of every transaction recorded on the ledger
+	Transactions() ([]Transaction, error)
+	TransactionsContext(ctx context.Context) ([]Transaction, error)
+	// CreateAddress generates a new address that can receive coins on the ledger
+	CreateAddress() (Address, error)
+}
+
+// InsufficientFundsError is returned by Send when the sending address holds fewer coins than requested
+type InsufficientFundsError struct {
+	Address Address
+}
+
+func (e *InsufficientFundsError) Error() string {
+	return fmt.Sprintf("insufficient funds in address %s", e.Address)
+}
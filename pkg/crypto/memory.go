@@ -0,0 +1,138 @@
+package crypto
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// MemoryLedger is an in-process Ledger used for offline tests and simulations
+// Accounts only exist in memory: coins are created with Mint and moved atomically with Send
+type MemoryLedger struct {
+	mu           sync.Mutex
+	balances     map[Address]Amount
+	transactions []Transaction
+}
+
+func NewMemoryLedger() *MemoryLedger {
+	return &MemoryLedger{
+		balances: make(map[Address]Amount),
+	}
+}
+
+// Mint creates size coins out of thin air in address, the same way the JobCoin UI seeds an address
+func (l *MemoryLedger) Mint(address Address, size Amount) error {
+	if !size.IsPositive() {
+		return fmt.Errorf("invalid amount %s: must be greater than zero", size)
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.balances[address] = l.balances[address].Add(size)
+	l.record("", address, size)
+	return nil
+}
+
+// Send moves coins from "from" to "to" - either the whole amount moves or nothing does
+// It fails with an InsufficientFundsError when "from" holds less than size
+func (l *MemoryLedger) Send(from Address, to Address, size Amount) error {
+	if !size.IsPositive() {
+		return fmt.Errorf("invalid amount %s: must be greater than zero", size)
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.balances[from].Cmp(size) < 0 {
+		return &InsufficientFundsError{Address: from}
+	}
+
+	l.balances[from] = l.balances[from].Sub(size)
+	l.balances[to] = l.balances[to].Add(size)
+	l.record(from, to, size)
+	return nil
+}
+
+// Balance returns the number of coins held by address, unknown addresses hold 0
+func (l *MemoryLedger) Balance(address Address) (Amount, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	return l.balances[address], nil
+}
+
+// CheckAddress returns the balance of address and the transactions sent to or from it
+func (l *MemoryLedger) CheckAddress(address Address) (*CheckAddressResponse, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	response := &CheckAddressResponse{
+		Balance:      l.balances[address],
+		Transactions: []Transaction{},
+	}
+	for _, transaction := range l.transactions {
+		if transaction.From == address || transaction.To == address {
+			response.Transactions = append(response.Transactions, transaction)
+		}
+	}
+	return response, nil
+}
+
+// Transactions returns a copy of the transaction log in the order the transactions happened
+func (l *MemoryLedger) Transactions() ([]Transaction, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	transactions := make([]Transaction, len(l.transactions))
+	copy(transactions, l.transactions)
+	return transactions, nil
+}
+
+// SendContext is Send, unless ctx is already done - the in-memory ledger answers right away so ctx is only checked up front
+func (l *MemoryLedger) SendContext(ctx context.Context, from Address, to Address, size Amount) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	return l.Send(from, to, size)
+}
+
+// BalanceContext is Balance, unless ctx is already done
+func (l *MemoryLedger) BalanceContext(ctx context.Context, address Address) (Amount, error) {
+	if err := ctx.Err(); err != nil {
+		return Zero, err
+	}
+	return l.Balance(address)
+}
+
+// CheckAddressContext is CheckAddress, unless ctx is already done
+func (l *MemoryLedger) CheckAddressContext(ctx context.Context, address Address) (*CheckAddressResponse, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	return l.CheckAddress(address)
+}
+
+// TransactionsContext is Transactions, unless ctx is already done
+func (l *MemoryLedger) TransactionsContext(ctx context.Context) ([]Transaction, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	return l.Transactions()
+}
+
+// CreateAddress generates a new, empty address
+func (l *MemoryLedger) CreateAddress() (Address, error) {
+	return CreateAddress()
+}
+
+// record appends a transaction to the log, the caller must hold the lock
+func (l *MemoryLedger) record(from Address, to Address, amount Amount) {
+	l.transactions = append(l.transactions, Transaction{
+		Timestamp: time.Now().UTC(),
+		From:      from,
+		To:        to,
+		Amount:    amount,
+	})
+}
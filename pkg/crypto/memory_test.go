@@ -0,0 +1,67 @@
+package crypto
+
+import (
+	"testing"
+)
+
+func TestMemoryLedger_Send(t *testing.T) {
+	ledger := NewMemoryLedger()
+	if err := ledger.Mint("Genesis", MustParseAmount("5")); err != nil {
+		t.Fatalf("error minting coins: %s", err)
+	}
+
+	if err := ledger.Send("Genesis", "Alice", MustParseAmount("2")); err != nil {
+		t.Fatalf("error sending coins: %s", err)
+	}
+
+	tableTests := []struct {
+		address Address
+		balance Amount
+	}{
+		{"Genesis", MustParseAmount("3")},
+		{"Alice", MustParseAmount("2")},
+		{"Nobody", Zero},
+	}
+	for _, tt := range tableTests {
+		balance, err := ledger.Balance(tt.address)
+		if err != nil {
+			t.Fatalf("error checking balance of %s: %s", tt.address, err)
+		}
+		if balance != tt.balance {
+			t.Errorf("address %s got balance %s, want %s", tt.address, balance, tt.balance)
+		}
+	}
+
+	transactions, err := ledger.Transactions()
+	if err != nil {
+		t.Fatalf("error listing transactions: %s", err)
+	}
+	if len(transactions) != 2 {
+		t.Fatalf("expected 2 transactions, got %d", len(transactions))
+	}
+	if transactions[1].From != "Genesis" || transactions[1].To != "Alice" || transactions[1].Amount != MustParseAmount("2") {
+		t.Errorf("unexpected transaction recorded: %+v", transactions[1])
+	}
+}
+
+func TestMemoryLedger_InsufficientFunds(t *testing.T) {
+	ledger := NewMemoryLedger()
+	if err := ledger.Mint("Genesis", MustParseAmount("1")); err != nil {
+		t.Fatalf("error minting coins: %s", err)
+	}
+
+	err := ledger.Send("Genesis", "Alice", MustParseAmount("1.5"))
+	if _, ok := err.(*InsufficientFundsError); !ok {
+		t.Fatalf("expected insufficient funds error, got %v", err)
+	}
+
+	// a failed send must not move any coins
+	balance, _ := ledger.Balance("Genesis")
+	if balance != MustParseAmount("1") {
+		t.Errorf("expected Genesis to still hold 1, got %s", balance)
+	}
+	transactions, _ := ledger.Transactions()
+	if len(transactions) != 1 {
+		t.Errorf("expected only the mint transaction, got %d", len(transactions))
+	}
+}
@@ -1,13 +1,37 @@
 package crypto
 
+import "time"
+
 type SendCoinRequest struct {
 	From   Address `json:"fromAddress"`
 	To     Address `json:"toAddress"`
 	Amount Amount  `json:"amount"`
 }
 
-// TODO parse additional address information: transaction array
-// TODO for now we are only interested in the total balance of the address being greater than zero, assuming its new
+// CheckAddressResponse is everything the ledger knows about an address: its balance and every transaction sent to or from it
+// Transactions are in the order they happened
 type CheckAddressResponse struct {
-	Balance Amount `json:"balance"`
-}
\ No newline at end of file
+	Balance      Amount        `json:"balance"`
+	Transactions []Transaction `json:"transactions"`
+}
+
+// Transaction is a single movement of coins recorded on the ledger
+// From is empty when the coins were created out of thin air (for example seeding the Genesis address)
+type Transaction struct {
+	Timestamp time.Time `json:"timestamp"`
+	From      Address   `json:"fromAddress,omitempty"`
+	To        Address   `json:"toAddress"`
+	Amount    Amount    `json:"amount"`
+}
+
+// Incoming returns the transactions that sent coins to address, in the order they happened
+// Each one is a separate deposit, and its From is the address to refund to
+func Incoming(transactions []Transaction, address Address) []Transaction {
+	var incoming []Transaction
+	for _, transaction := range transactions {
+		if transaction.To == address && transaction.From != address {
+			incoming = append(incoming, transaction)
+		}
+	}
+	return incoming
+}
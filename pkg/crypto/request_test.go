@@ -0,0 +1,45 @@
+package crypto
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+)
+
+func TestCheckAddressResponse_JSON(t *testing.T) {
+	// body as returned by GET /api/addresses/Alice
+	body := `{
+		"balance": "7.5",
+		"transactions": [
+			{"timestamp": "2019-09-20T13:10:01.210Z", "toAddress": "Alice", "amount": "10"},
+			{"timestamp": "2019-09-20T13:12:42.000Z", "fromAddress": "Alice", "toAddress": "Bob", "amount": "2.5"},
+			{"timestamp": "2019-09-20T13:15:00.000Z", "fromAddress": "Carol", "toAddress": "Alice", "amount": "0.5"}
+		]
+	}`
+
+	response := &CheckAddressResponse{}
+	if err := json.Unmarshal([]byte(body), response); err != nil {
+		t.Fatalf("error parsing response: %s", err)
+	}
+
+	if response.Balance != MustParseAmount("7.5") {
+		t.Errorf("expected balance 7.5, got %s", response.Balance)
+	}
+	if len(response.Transactions) != 3 {
+		t.Fatalf("expected 3 transactions, got %d", len(response.Transactions))
+	}
+
+	expected := time.Date(2019, 9, 20, 13, 12, 42, 0, time.UTC)
+	if second := response.Transactions[1]; !second.Timestamp.Equal(expected) || second.From != "Alice" ||
+		second.To != "Bob" || second.Amount != MustParseAmount("2.5") {
+		t.Errorf("unexpected transaction parsed: %+v", second)
+	}
+
+	incoming := Incoming(response.Transactions, "Alice")
+	if len(incoming) != 2 {
+		t.Fatalf("expected 2 incoming transactions, got %d", len(incoming))
+	}
+	if incoming[0].From != "" || incoming[1].From != "Carol" {
+		t.Errorf("unexpected senders %q and %q", incoming[0].From, incoming[1].From)
+	}
+}
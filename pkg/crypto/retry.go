@@ -0,0 +1,205 @@
+package crypto
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"math/rand"
+	"net"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+// DefaultBackoff retries a ledger call up to 5 times over a few seconds
+var DefaultBackoff = Backoff{Attempts: 5, Base: 200 * time.Millisecond, Max: 5 * time.Second}
+
+// StatusError is returned when the ledger answers with an http status it does not expect
+type StatusError struct {
+	Status int
+	Target string
+}
+
+func (e *StatusError) Error() string {
+	return fmt.Sprintf("unexpected status %d from %s", e.Status, e.Target)
+}
+
+// Retryable reports whether a failed ledger call may succeed when tried again
+// Network failures, timeouts and server side errors are retryable, anything the ledger refused outright
+// (insufficient funds, a bad request) is permanent and so is any error gtumbler does not recognise
+// A call the caller cancelled is never retried
+func Retryable(err error) bool {
+	if err == context.Canceled {
+		return false
+	}
+	switch e := err.(type) {
+	case *InsufficientFundsError:
+		return false
+	case *StatusError:
+		return e.Status >= http.StatusInternalServerError || e.Status == http.StatusTooManyRequests ||
+			e.Status == http.StatusRequestTimeout
+	case *url.Error:
+		return e.Err != context.Canceled
+	case net.Error:
+		return true
+	default:
+		return false
+	}
+}
+
+// Backoff describes how a failed ledger call is retried: up to Attempts tries in total, waiting a random time
+// between zero and Base doubled after every failed try, but never more than Max
+// The random wait keeps many jobs that failed together from hammering the ledger together again
+type Backoff struct {
+	Attempts int
+	Base     time.Duration
+	Max      time.Duration
+}
+
+// delay returns how long to wait after the given failed try, counting from zero
+func (b Backoff) delay(attempt int) time.Duration {
+	ceiling := b.Max
+	if attempt < 32 && b.Base<<uint(attempt) < b.Max {
+		ceiling = b.Base << uint(attempt)
+	}
+	if ceiling <= 0 {
+		return 0
+	}
+	return time.Duration(rand.Int63n(int64(ceiling) + 1))
+}
+
+// Retry calls do until it succeeds, fails with an error that is not Retryable or runs out of attempts
+func (b Backoff) Retry(do func() error) error {
+	return b.RetryContext(context.Background(), do)
+}
+
+// RetryContext is Retry, but stops waiting and trying as soon as ctx is done
+func (b Backoff) RetryContext(ctx context.Context, do func() error) error {
+	var err error
+	for attempt := 0; attempt < b.Attempts || attempt == 0; attempt++ {
+		if attempt > 0 {
+			timer := time.NewTimer(b.delay(attempt - 1))
+			select {
+			case <-ctx.Done():
+				timer.Stop()
+				return err
+			case <-timer.C:
+			}
+		}
+		err = do()
+		if err == nil || !Retryable(err) || ctx.Err() != nil {
+			return err
+		}
+		log.Printf("ledger call failed on try %d of %d: %s", attempt+1, b.Attempts, err)
+	}
+	return err
+}
+
+// RetryLedger is a Ledger that retries the calls of another Ledger with Backoff
+// Sends are idempotent: a send that failed on our end may still have gone through on the ledger, so before trying again
+// the history of the sender is checked and a send that already landed is not repeated
+type RetryLedger struct {
+	Ledger
+	Backoff Backoff
+}
+
+func NewRetryLedger(ledger Ledger) *RetryLedger {
+	return &RetryLedger{
+		Ledger:  ledger,
+		Backoff: DefaultBackoff,
+	}
+}
+
+// Send sends coins from "from" to "to", at most once however many tries it takes
+func (r *RetryLedger) Send(from Address, to Address, size Amount) error {
+	return r.SendContext(context.Background(), from, to, size)
+}
+
+// SendContext is Send, giving up once ctx is done
+func (r *RetryLedger) SendContext(ctx context.Context, from Address, to Address, size Amount) error {
+	seen, err := Seen(ctx, r, from)
+	if err != nil {
+		return err
+	}
+
+	tried := false
+	return r.Backoff.RetryContext(ctx, func() error {
+		if tried {
+			landed, err := Landed(ctx, r.Ledger, from, to, size, seen)
+			if err != nil || landed {
+				return err
+			}
+		}
+		tried = true
+		return r.Ledger.SendContext(ctx, from, to, size)
+	})
+}
+
+func (r *RetryLedger) Balance(address Address) (Amount, error) {
+	return r.BalanceContext(context.Background(), address)
+}
+
+func (r *RetryLedger) BalanceContext(ctx context.Context, address Address) (Amount, error) {
+	balance := Zero
+	err := r.Backoff.RetryContext(ctx, func() error {
+		var err error
+		balance, err = r.Ledger.BalanceContext(ctx, address)
+		return err
+	})
+	return balance, err
+}
+
+func (r *RetryLedger) CheckAddress(address Address) (*CheckAddressResponse, error) {
+	return r.CheckAddressContext(context.Background(), address)
+}
+
+func (r *RetryLedger) CheckAddressContext(ctx context.Context, address Address) (*CheckAddressResponse, error) {
+	var response *CheckAddressResponse
+	err := r.Backoff.RetryContext(ctx, func() error {
+		var err error
+		response, err = r.Ledger.CheckAddressContext(ctx, address)
+		return err
+	})
+	return response, err
+}
+
+func (r *RetryLedger) Transactions() ([]Transaction, error) {
+	return r.TransactionsContext(context.Background())
+}
+
+func (r *RetryLedger) TransactionsContext(ctx context.Context) ([]Transaction, error) {
+	var transactions []Transaction
+	err := r.Backoff.RetryContext(ctx, func() error {
+		var err error
+		transactions, err = r.Ledger.TransactionsContext(ctx)
+		return err
+	})
+	return transactions, err
+}
+
+// Seen returns how many transactions address took part in so far, to tell later with Landed whether a send from it went through
+func Seen(ctx context.Context, ledger Ledger, address Address) (int, error) {
+	history, err := ledger.CheckAddressContext(ctx, address)
+	if err != nil {
+		return 0, err
+	}
+	return len(history.Transactions), nil
+}
+
+// Landed reports whether a send of size from "from" to "to" shows up in the history of "from" after the first seen
+// transactions, that is whether a send made after the history was seen went through
+func Landed(ctx context.Context, ledger Ledger, from Address, to Address, size Amount, seen int) (bool, error) {
+	history, err := ledger.CheckAddressContext(ctx, from)
+	if err != nil {
+		return false, err
+	}
+	if seen > len(history.Transactions) {
+		seen = len(history.Transactions)
+	}
+	for _, transaction := range history.Transactions[seen:] {
+		if transaction.From == from && transaction.To == to && transaction.Amount == size {
+			return true, nil
+		}
+	}
+	return false, nil
+}
@@ -0,0 +1,137 @@
+package crypto
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"testing"
+	"time"
+)
+
+// flakyLedger is a MemoryLedger whose sends fail with a server error a number of times
+// When land is set the failed sends still go through, like a response lost on its way back
+type flakyLedger struct {
+	*MemoryLedger
+	failures int
+	land     bool
+	sends    int
+}
+
+func (f *flakyLedger) Send(from Address, to Address, size Amount) error {
+	return f.SendContext(context.Background(), from, to, size)
+}
+
+func (f *flakyLedger) SendContext(ctx context.Context, from Address, to Address, size Amount) error {
+	f.sends++
+	if f.failures == 0 {
+		return f.MemoryLedger.Send(from, to, size)
+	}
+	f.failures--
+	if f.land {
+		if err := f.MemoryLedger.Send(from, to, size); err != nil {
+			return err
+		}
+	}
+	return &StatusError{Status: http.StatusBadGateway, Target: "/transactions"}
+}
+
+func TestRetryable(t *testing.T) {
+	tableTests := []struct {
+		err       error
+		retryable bool
+	}{
+		{&InsufficientFundsError{Address: "Alice"}, false},
+		{&StatusError{Status: http.StatusServiceUnavailable}, true},
+		{&StatusError{Status: http.StatusTooManyRequests}, true},
+		{&StatusError{Status: http.StatusBadRequest}, false},
+		{&url.Error{Op: "Post", URL: "/transactions", Err: errors.New("connection reset")}, true},
+		{errors.New("something else"), false},
+	}
+
+	for i, tt := range tableTests {
+		t.Run(fmt.Sprintf("test %d", i), func(t *testing.T) {
+			if r := Retryable(tt.err); r != tt.retryable {
+				t.Errorf("record %d got %t, want %t", i, r, tt.retryable)
+			}
+		})
+	}
+}
+
+func TestRetryLedger_Send(t *testing.T) {
+	tableTests := []struct {
+		failures int
+		land     bool
+		sends    int
+		err      bool
+	}{
+		{0, false, 1, false},
+		// failed sends that did not go through are tried again
+		{2, false, 3, false},
+		// a send that went through although it failed is not repeated
+		{1, true, 1, false},
+		// giving up after the last attempt
+		{5, false, 3, true},
+	}
+
+	for i, tt := range tableTests {
+		t.Run(fmt.Sprintf("test %d", i), func(t *testing.T) {
+			flaky := &flakyLedger{MemoryLedger: NewMemoryLedger(), failures: tt.failures, land: tt.land}
+			if err := flaky.Mint("Genesis", MustParseAmount("5")); err != nil {
+				t.Fatalf("error minting coins: %s", err)
+			}
+			ledger := NewRetryLedger(flaky)
+			ledger.Backoff = Backoff{Attempts: 3, Base: time.Millisecond, Max: 5 * time.Millisecond}
+
+			err := ledger.Send("Genesis", "Alice", MustParseAmount("2"))
+			if (err != nil) != tt.err {
+				t.Errorf("record %d got error %v, want error %t", i, err, tt.err)
+			}
+			if flaky.sends != tt.sends {
+				t.Errorf("record %d sent %d times, want %d", i, flaky.sends, tt.sends)
+			}
+			expected := MustParseAmount("2")
+			if tt.err {
+				expected = Zero
+			}
+			if balance, _ := ledger.Balance("Alice"); balance != expected {
+				t.Errorf("record %d paid Alice %s, want %s", i, balance, expected)
+			}
+		})
+	}
+}
+
+func TestRetryLedger_SendPermanent(t *testing.T) {
+	flaky := &flakyLedger{MemoryLedger: NewMemoryLedger()}
+	ledger := NewRetryLedger(flaky)
+	ledger.Backoff = Backoff{Attempts: 3, Base: time.Millisecond, Max: 5 * time.Millisecond}
+
+	err := ledger.Send("Nobody", "Alice", MustParseAmount("1"))
+	if _, ok := err.(*InsufficientFundsError); !ok {
+		t.Errorf("expected an InsufficientFundsError, got %v", err)
+	}
+	if flaky.sends != 1 {
+		t.Errorf("expected insufficient funds not to be retried, sent %d times", flaky.sends)
+	}
+}
+
+func TestRetryLedger_SendCancelled(t *testing.T) {
+	flaky := &flakyLedger{MemoryLedger: NewMemoryLedger(), failures: 2}
+	ledger := NewRetryLedger(flaky)
+	ledger.Backoff = Backoff{Attempts: 3, Base: time.Hour, Max: time.Hour}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	err := ledger.SendContext(ctx, "Genesis", "Alice", MustParseAmount("1"))
+	if _, ok := err.(*StatusError); !ok {
+		t.Errorf("expected the last StatusError once the context is done, got %v", err)
+	}
+	if flaky.sends != 1 {
+		t.Errorf("expected no retries after the context is done, sent %d times", flaky.sends)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("expected the send to stop waiting when the context is done, it took %s", elapsed)
+	}
+}
@@ -0,0 +1,39 @@
+package jobcoin
+
+import (
+	"encoding/json"
+	"github.com/Denton24646/gtumbler/pkg/crypto"
+	"io/ioutil"
+)
+
+// Seed is the set of addresses created with coins when the server starts, replacing the manual step of
+// creating coins from the JobCoin UI. On disk it is a json object of address to amount, for example
+// {"Genesis": "100", "House1": "10"}
+type Seed map[crypto.Address]crypto.Amount
+
+// LoadSeed reads a seed file from path
+func LoadSeed(path string) (Seed, error) {
+	file, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	seed := Seed{}
+	err = json.Unmarshal(file, &seed)
+	if err != nil {
+		return nil, err
+	}
+
+	return seed, nil
+}
+
+// Apply creates the seeded coins in ledger
+func (s Seed) Apply(ledger *crypto.MemoryLedger) error {
+	for address, amount := range s {
+		err := ledger.Mint(address, amount)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
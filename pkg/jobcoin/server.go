@@ -0,0 +1,139 @@
+package jobcoin
+
+import (
+	"encoding/json"
+	"github.com/Denton24646/gtumbler/pkg/crypto"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"strings"
+)
+
+// The jobcoin server is a local stand-in for jobcoin.gemini.com. It serves the same api the crypto.JobCoin ledger speaks:
+// 1. POST /api/transactions sends coins between two addresses, responding 422 when the sender has insufficient funds
+// 2. GET /api/addresses/{address} returns the balance and transactions of an address
+// 3. GET /api/transactions returns every transaction on the ledger
+
+const (
+	transactionsPath = "/api/transactions"
+	addressesPath    = "/api/addresses/"
+)
+
+type Server struct {
+	// ledger holds the actual balances, usually a crypto.MemoryLedger seeded on startup
+	ledger crypto.Ledger
+	mux    *http.ServeMux
+}
+
+// StatusResponse is the body of a successful POST /api/transactions
+type StatusResponse struct {
+	Status string `json:"status"`
+}
+
+// ErrorResponse is the body of a failed request
+type ErrorResponse struct {
+	Error string `json:"error"`
+}
+
+func New(ledger crypto.Ledger) *Server {
+	s := &Server{
+		ledger: ledger,
+		mux:    http.NewServeMux(),
+	}
+	s.mux.HandleFunc(transactionsPath, s.Transactions)
+	s.mux.HandleFunc(addressesPath, s.Address)
+	return s
+}
+
+func (s *Server) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	s.mux.ServeHTTP(w, req)
+}
+
+// Transactions lists all transactions on GET and sends coins on POST
+func (s *Server) Transactions(w http.ResponseWriter, req *http.Request) {
+	switch req.Method {
+	case http.MethodGet:
+		transactions, err := s.ledger.Transactions()
+		if err != nil {
+			writeJSON(w, http.StatusInternalServerError, &ErrorResponse{Error: err.Error()})
+			return
+		}
+		writeJSON(w, http.StatusOK, nonNil(transactions))
+	case http.MethodPost:
+		s.send(w, req)
+	default:
+		writeJSON(w, http.StatusMethodNotAllowed, &ErrorResponse{Error: "method not allowed"})
+	}
+}
+
+func (s *Server) send(w http.ResponseWriter, req *http.Request) {
+	request := &crypto.SendCoinRequest{}
+
+	body, err := ioutil.ReadAll(req.Body)
+	if err != nil {
+		writeJSON(w, http.StatusBadRequest, &ErrorResponse{Error: err.Error()})
+		return
+	}
+	defer req.Body.Close()
+
+	err = json.Unmarshal(body, request)
+	if err != nil || request.From == "" || request.To == "" {
+		writeJSON(w, http.StatusBadRequest, &ErrorResponse{Error: "fromAddress, toAddress and amount are required"})
+		return
+	}
+
+	err = s.ledger.Send(request.From, request.To, request.Amount)
+	if _, ok := err.(*crypto.InsufficientFundsError); ok {
+		writeJSON(w, http.StatusUnprocessableEntity, &ErrorResponse{Error: "Insufficient Funds"})
+		return
+	}
+	if err != nil {
+		writeJSON(w, http.StatusBadRequest, &ErrorResponse{Error: err.Error()})
+		return
+	}
+
+	log.Printf("sent %s coins from %s to %s", request.Amount, request.From, request.To)
+	writeJSON(w, http.StatusOK, &StatusResponse{Status: "OK"})
+}
+
+// Address returns the balance and the transactions sent to or from a single address
+func (s *Server) Address(w http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodGet {
+		writeJSON(w, http.StatusMethodNotAllowed, &ErrorResponse{Error: "method not allowed"})
+		return
+	}
+
+	address := crypto.Address(strings.TrimPrefix(req.URL.Path, addressesPath))
+	if address == "" {
+		writeJSON(w, http.StatusNotFound, &ErrorResponse{Error: "address is required"})
+		return
+	}
+
+	response, err := s.ledger.CheckAddress(address)
+	if err != nil {
+		writeJSON(w, http.StatusInternalServerError, &ErrorResponse{Error: err.Error()})
+		return
+	}
+	response.Transactions = nonNil(response.Transactions)
+
+	writeJSON(w, http.StatusOK, response)
+}
+
+func nonNil(transactions []crypto.Transaction) []crypto.Transaction {
+	if transactions == nil {
+		return []crypto.Transaction{}
+	}
+	return transactions
+}
+
+func writeJSON(w http.ResponseWriter, status int, body interface{}) {
+	res, err := json.Marshal(body)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_, _ = w.Write(res)
+}
@@ -0,0 +1,85 @@
+package jobcoin
+
+import (
+	"github.com/Denton24646/gtumbler/pkg/crypto"
+	"net/http/httptest"
+	"testing"
+)
+
+// newTestServer starts a jobcoin server with a seeded Genesis address and returns a JobCoin ledger pointed at it
+func newTestServer(t *testing.T) (*httptest.Server, *crypto.JobCoin) {
+	ledger := crypto.NewMemoryLedger()
+	err := Seed{"Genesis": crypto.MustParseAmount("10")}.Apply(ledger)
+	if err != nil {
+		t.Fatalf("error seeding ledger: %s", err)
+	}
+
+	server := httptest.NewServer(New(ledger))
+	return server, crypto.NewJobCoin(server.URL + "/api")
+}
+
+func TestServer_Send(t *testing.T) {
+	server, client := newTestServer(t)
+	defer server.Close()
+
+	err := client.Send("Genesis", "Alice", crypto.MustParseAmount("2.5"))
+	if err != nil {
+		t.Fatalf("error sending coins: %s", err)
+	}
+
+	balance, err := client.Balance("Alice")
+	if err != nil {
+		t.Fatalf("error checking balance: %s", err)
+	}
+	if balance != crypto.MustParseAmount("2.5") {
+		t.Errorf("expected Alice to hold 2.5, got %s", balance)
+	}
+
+	transactions, err := client.Transactions()
+	if err != nil {
+		t.Fatalf("error listing transactions: %s", err)
+	}
+	if len(transactions) != 2 {
+		t.Errorf("expected the seed and the send transactions, got %d", len(transactions))
+	}
+}
+
+func TestServer_InsufficientFunds(t *testing.T) {
+	server, client := newTestServer(t)
+	defer server.Close()
+
+	err := client.Send("Genesis", "Alice", crypto.MustParseAmount("20"))
+	if _, ok := err.(*crypto.InsufficientFundsError); !ok {
+		t.Errorf("expected insufficient funds error, got %v", err)
+	}
+}
+
+func TestServer_CheckAddress(t *testing.T) {
+	server, client := newTestServer(t)
+	defer server.Close()
+
+	for _, amount := range []string{"1", "2"} {
+		err := client.Send("Genesis", "Alice", crypto.MustParseAmount(amount))
+		if err != nil {
+			t.Fatalf("error sending coins: %s", err)
+		}
+	}
+
+	response, err := client.CheckAddress("Alice")
+	if err != nil {
+		t.Fatalf("error checking address: %s", err)
+	}
+	if response.Balance != crypto.MustParseAmount("3") {
+		t.Errorf("expected Alice to hold 3, got %s", response.Balance)
+	}
+
+	deposits := crypto.Incoming(response.Transactions, "Alice")
+	if len(deposits) != 2 {
+		t.Fatalf("expected 2 deposits, got %d", len(deposits))
+	}
+	for _, deposit := range deposits {
+		if deposit.From != "Genesis" || deposit.Timestamp.IsZero() {
+			t.Errorf("unexpected deposit %+v", deposit)
+		}
+	}
+}
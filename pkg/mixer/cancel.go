@@ -0,0 +1,44 @@
+package mixer
+
+import (
+	"fmt"
+	"github.com/Denton24646/gtumbler/pkg/models"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// cancelPath is the prefix of the cancel endpoint, the rest of the path is the customer id
+const cancelPath = "/cancel/"
+
+// Cancel is the POST /cancel/{id} endpoint for the mixer - it cancels a job that has not received its deposit yet
+// Anything deposited to a cancelled job is refunded to its sender
+func (m *Mixer) Cancel(w http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodPost {
+		writeError(w, http.StatusMethodNotAllowed, models.ErrMethodNotAllowed, "use POST to cancel a customer")
+		return
+	}
+
+	id, err := strconv.Atoi(strings.TrimPrefix(req.URL.Path, cancelPath))
+	if err != nil {
+		writeError(w, http.StatusNotFound, models.ErrNotFound, "customer id must be a number")
+		return
+	}
+	if _, ok := m.customer(id); !ok {
+		writeError(w, http.StatusNotFound, models.ErrNotFound, fmt.Sprintf("no customer with id %d", id))
+		return
+	}
+
+	customer, err := m.updateCustomer(id, func(c *CustomerData) error {
+		if c.State != AwaitingDeposit {
+			return fmt.Errorf("customer %d is %s and can no longer be cancelled", id, c.State)
+		}
+		return c.transition(Cancelled, "cancelled by the customer")
+	})
+	if err != nil {
+		writeError(w, http.StatusConflict, models.ErrInvalidState, err.Error())
+		return
+	}
+
+	writeJSON(w, http.StatusOK, status(id, customer))
+}
@@ -0,0 +1,234 @@
+package mixer
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/Denton24646/gtumbler/pkg/crypto"
+	"github.com/Denton24646/gtumbler/pkg/mixer/tumbler"
+	"io/ioutil"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// defaultTag is the struct tag holding the default of a Config field
+const defaultTag = "cfgDefault"
+
+// Config is the configuration of the mixer service: the defaults in its cfgDefault tags, overridden by a json file read
+// with LoadConfig and then by goconfig from the environment and the command line
+// Amounts, durations and lists are kept as strings so they can be set from the environment:
+// amounts are parsed with crypto.ParseAmount, durations with time.ParseDuration and HouseAddresses is comma separated
+// HouseAddresses are the already funded houses the wallet starts out with, Houses is how many active houses it keeps
+// and HouseUses how many transactions a house takes part in before it is retired (zero for no limit)
+type Config struct {
+	Listen            string         `cfgDefault:":8989"`
+	LedgerURL         string         `cfgDefault:"http://jobcoin.gemini.com/survey/api"`
+	StorePath         string         `cfgDefault:"gtumbler-data"`
+	HouseAddresses    string         `cfgDefault:"House1,House2,House3,House4,House5"`
+	FeeAddress        crypto.Address `cfgDefault:"HouseFees"`
+	MinDeposit        string         `cfgDefault:"0.1"`
+	MaxDeposit        string         `cfgDefault:"10"`
+	PollInterval      string         `cfgDefault:"10s"`
+	WatchInterval     string         `cfgDefault:"10s"`
+	WatchWindow       string         `cfgDefault:"168h"`
+	DepositWindow     string         `cfgDefault:"24h"`
+	DefaultDelay      string         `cfgDefault:"10m"`
+	MaxDelay          string         `cfgDefault:"24h"`
+	RoundInterval     string         `cfgDefault:"1m"`
+	RoundHouses       int            `cfgDefault:"3"`
+	Houses            int            `cfgDefault:"5"`
+	HouseUses         int            `cfgDefault:"20"`
+	SweepInterval     string         `cfgDefault:"1m"`
+	RebalanceInterval string         `cfgDefault:"10m"`
+	ShutdownTimeout   string         `cfgDefault:"30s"`
+	Fee               FeeConfig
+	Strategy          tumbler.StrategyConfig
+}
+
+// DefaultConfig returns the configuration made of the cfgDefault tags alone
+// The defaults are applied up front rather than by goconfig, which takes every zero number for unset and would replace
+// a zero the config file sets on purpose (HouseUses or RoundHouses of 0, a 0% fee) with its default
+func DefaultConfig() Config {
+	config := Config{}
+	err := setDefaults(reflect.ValueOf(&config).Elem())
+	if err != nil {
+		panic(err)
+	}
+	return config
+}
+
+// setDefaults sets every field of the struct value to its cfgDefault tag, going into nested structs
+func setDefaults(value reflect.Value) error {
+	for i := 0; i < value.NumField(); i++ {
+		field := value.Type().Field(i)
+		to := value.Field(i)
+		if to.Kind() == reflect.Struct {
+			err := setDefaults(to)
+			if err != nil {
+				return err
+			}
+			continue
+		}
+
+		raw, ok := field.Tag.Lookup(defaultTag)
+		if !ok {
+			continue
+		}
+		switch to.Kind() {
+		case reflect.String:
+			to.SetString(raw)
+		case reflect.Int:
+			n, err := strconv.Atoi(raw)
+			if err != nil {
+				return fmt.Errorf("default of %s: %s", field.Name, err)
+			}
+			to.SetInt(int64(n))
+		case reflect.Float64:
+			f, err := strconv.ParseFloat(raw, 64)
+			if err != nil {
+				return fmt.Errorf("default of %s: %s", field.Name, err)
+			}
+			to.SetFloat(f)
+		default:
+			return fmt.Errorf("default of %s: unsupported kind %s", field.Name, to.Kind())
+		}
+	}
+	return nil
+}
+
+// LoadConfig reads a json config file from path on top of DefaultConfig, fields missing from the file keep their default
+// When the file cannot be read the defaults are returned along with the error
+func LoadConfig(path string) (Config, error) {
+	config := DefaultConfig()
+	file, err := ioutil.ReadFile(path)
+	if err != nil {
+		return config, err
+	}
+
+	err = json.Unmarshal(file, &config)
+	if err != nil {
+		return config, err
+	}
+
+	return config, nil
+}
+
+// Configure applies everything in config that concerns the mixer itself, the listen address, ledger and store are up to the caller
+func (m *Mixer) Configure(config Config) error {
+	var houses []crypto.Address
+	for _, house := range strings.Split(config.HouseAddresses, ",") {
+		house = strings.TrimSpace(house)
+		if house == "" {
+			continue
+		}
+		if !validAddress(crypto.Address(house)) {
+			return fmt.Errorf("house address %q is not valid", house)
+		}
+		houses = append(houses, crypto.Address(house))
+	}
+	if len(houses) == 0 {
+		return fmt.Errorf("at least one house address is required")
+	}
+	if !validAddress(config.FeeAddress) {
+		return fmt.Errorf("fee address %q is not valid", config.FeeAddress)
+	}
+
+	limits, err := parseLimits(config.MinDeposit, config.MaxDeposit)
+	if err != nil {
+		return err
+	}
+
+	// the delays and rounds may be zero to send everything right away, the intervals may not
+	var poll, watch, watchWindow, window, defaultDelay, maxDelay, roundInterval, sweep, rebalance, shutdown time.Duration
+	for _, interval := range []struct {
+		name     string
+		value    string
+		to       *time.Duration
+		zeroOkay bool
+	}{
+		{"poll interval", config.PollInterval, &poll, false},
+		{"watch interval", config.WatchInterval, &watch, false},
+		{"watch window", config.WatchWindow, &watchWindow, false},
+		{"deposit window", config.DepositWindow, &window, false},
+		{"default delay", config.DefaultDelay, &defaultDelay, true},
+		{"maximum delay", config.MaxDelay, &maxDelay, true},
+		{"round interval", config.RoundInterval, &roundInterval, true},
+		{"sweep interval", config.SweepInterval, &sweep, false},
+		{"rebalance interval", config.RebalanceInterval, &rebalance, false},
+		{"shutdown timeout", config.ShutdownTimeout, &shutdown, false},
+	} {
+		d, err := time.ParseDuration(interval.value)
+		if err != nil {
+			return fmt.Errorf("%s: %s", interval.name, err)
+		}
+		if d < 0 || (d == 0 && !interval.zeroOkay) {
+			return fmt.Errorf("%s must be positive, got %s", interval.name, d)
+		}
+		*interval.to = d
+	}
+	if config.RoundHouses < 0 {
+		return fmt.Errorf("round houses must not be negative, got %d", config.RoundHouses)
+	}
+	if config.Houses < 1 {
+		return fmt.Errorf("at least one active house is required, got %d", config.Houses)
+	}
+	if config.HouseUses < 0 {
+		return fmt.Errorf("house uses must not be negative, got %d", config.HouseUses)
+	}
+	if defaultDelay > maxDelay {
+		return fmt.Errorf("default delay %s is longer than the maximum delay %s", defaultDelay, maxDelay)
+	}
+
+	feePolicy, err := NewFeePolicy(config.Fee)
+	if err != nil {
+		return err
+	}
+	// a fixed fee as large as the smallest deposit would leave nothing of it to pay out
+	if quote := feePolicy.Quote(limits.Min); quote.Flat.Cmp(limits.Min) >= 0 || quote.Minimum.Cmp(limits.Min) >= 0 {
+		return fmt.Errorf("flat fee %s and minimum fee %s must be below the minimum deposit %s", quote.Flat, quote.Minimum,
+			limits.Min)
+	}
+	strategy, err := tumbler.NewStrategy(config.Strategy)
+	if err != nil {
+		return err
+	}
+
+	m.Wallet.Seeds = houses
+	m.Wallet.Size = config.Houses
+	m.Wallet.MaxUses = config.HouseUses
+	m.FeeAddress = config.FeeAddress
+	m.FeePolicy = feePolicy
+	m.Strategy = strategy
+	m.Limits = limits
+	m.PollInterval = poll
+	m.WatchInterval = watch
+	m.WatchWindow = watchWindow
+	m.DepositWindow = window
+	m.DefaultDelay = defaultDelay
+	m.MaxDelay = maxDelay
+	m.Pool.Interval = roundInterval
+	m.Pool.Houses = config.RoundHouses
+	m.SweepInterval = sweep
+	m.RebalanceInterval = rebalance
+	m.ShutdownTimeout = shutdown
+	return nil
+}
+
+// parseLimits parses the deposit limits, the minimum has to be below the maximum
+func parseLimits(min, max string) (tumbler.Limits, error) {
+	limits := tumbler.Limits{}
+	var err error
+	limits.Min, err = crypto.ParseAmount(min)
+	if err != nil {
+		return limits, fmt.Errorf("minimum deposit: %s", err)
+	}
+	limits.Max, err = crypto.ParseAmount(max)
+	if err != nil {
+		return limits, fmt.Errorf("maximum deposit: %s", err)
+	}
+	if limits.Min.Cmp(limits.Max) >= 0 {
+		return limits, fmt.Errorf("minimum deposit %s must be below the maximum deposit %s", limits.Min, limits.Max)
+	}
+	return limits, nil
+}
@@ -0,0 +1,195 @@
+package mixer
+
+import (
+	"fmt"
+	"github.com/Denton24646/gtumbler/pkg/crypto"
+	"github.com/Denton24646/gtumbler/pkg/mixer/tumbler"
+	"github.com/Denton24646/gtumbler/pkg/store"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// defaultConfig is the configuration DefaultConfig produces, spelled out
+func defaultConfig() Config {
+	return Config{
+		Listen:            ":8989",
+		LedgerURL:         crypto.JobCoinURL,
+		StorePath:         "gtumbler-data",
+		HouseAddresses:    "House1,House2,House3,House4,House5",
+		FeeAddress:        "HouseFees",
+		MinDeposit:        "0.1",
+		MaxDeposit:        "10",
+		PollInterval:      "10s",
+		WatchInterval:     "10s",
+		WatchWindow:       "168h",
+		DepositWindow:     "24h",
+		DefaultDelay:      "10m",
+		MaxDelay:          "24h",
+		RoundInterval:     "1m",
+		RoundHouses:       3,
+		Houses:            5,
+		HouseUses:         20,
+		SweepInterval:     "1m",
+		RebalanceInterval: "10m",
+		ShutdownTimeout:   "30s",
+		Fee:               FeeConfig{Policy: "random", Flat: "0", Percent: 0.5, MaxPercent: 1, Tiers: "0:1,1:0.5,5:0.25", Minimum: "0"},
+		Strategy:          tumbler.StrategyConfig{Kind: "fixed", MinChunks: 2, MaxChunks: 8, MinChunk: "0.01"},
+	}
+}
+
+func TestMixer_Configure(t *testing.T) {
+	config := defaultConfig()
+	config.HouseAddresses = "Pool1, Pool2,,Pool3"
+	config.FeeAddress = "Fees"
+	config.MaxDeposit = "25"
+	config.PollInterval = "1s"
+	config.DepositWindow = "1h"
+	config.DefaultDelay = "0s"
+	config.RoundInterval = "30s"
+	config.Houses = 8
+	config.Fee = FeeConfig{Policy: "percent", Percent: 1}
+	config.Strategy.Kind = "random"
+
+	testMixer := New(newTestLedger(t), store.NewMemory())
+	err := testMixer.Configure(config)
+	if err != nil {
+		t.Fatalf("error configuring mixer: %s", err)
+	}
+
+	if fmt.Sprint(testMixer.Wallet.Seeds) != "[Pool1 Pool2 Pool3]" || testMixer.FeeAddress != "Fees" {
+		t.Errorf("unexpected addresses %v and %s", testMixer.Wallet.Seeds, testMixer.FeeAddress)
+	}
+	if testMixer.Wallet.Size != 8 || testMixer.Wallet.MaxUses != 20 || testMixer.SweepInterval != time.Minute {
+		t.Errorf("unexpected wallet of %d houses used %d times, swept every %s", testMixer.Wallet.Size,
+			testMixer.Wallet.MaxUses, testMixer.SweepInterval)
+	}
+	if testMixer.Limits.Check(crypto.MustParseAmount("20")) != nil {
+		t.Errorf("expected a deposit of 20 to be accepted with a maximum of 25")
+	}
+	if testMixer.PollInterval != time.Second || testMixer.WatchInterval != 10*time.Second || testMixer.DepositWindow != time.Hour {
+		t.Errorf("unexpected intervals %s, %s and %s", testMixer.PollInterval, testMixer.WatchInterval, testMixer.DepositWindow)
+	}
+	if testMixer.DefaultDelay != 0 || testMixer.MaxDelay != 24*time.Hour {
+		t.Errorf("unexpected delays %s and %s", testMixer.DefaultDelay, testMixer.MaxDelay)
+	}
+	if testMixer.Pool.Interval != 30*time.Second || testMixer.Pool.Houses != 3 {
+		t.Errorf("unexpected rounds of %s over %d houses", testMixer.Pool.Interval, testMixer.Pool.Houses)
+	}
+	if quote := testMixer.FeePolicy.Quote(crypto.Zero); quote.Rate != 0.01 {
+		t.Errorf("expected a 1%% fee, got %+v", quote)
+	}
+	if _, ok := testMixer.Strategy.(tumbler.RandomStrategy); !ok {
+		t.Errorf("expected a random strategy, got %T", testMixer.Strategy)
+	}
+}
+
+func TestMixer_ConfigureInvalid(t *testing.T) {
+	tableTests := []func(c *Config){
+		func(c *Config) { c.HouseAddresses = " , " },
+		func(c *Config) { c.HouseAddresses = "House1,not a house" },
+		func(c *Config) { c.FeeAddress = "" },
+		func(c *Config) { c.MinDeposit = "a little" },
+		func(c *Config) { c.MaxDeposit = "0.1" },
+		func(c *Config) { c.PollInterval = "10" },
+		func(c *Config) { c.DepositWindow = "-1h" },
+		func(c *Config) { c.DefaultDelay = "2h"; c.MaxDelay = "1h" },
+		func(c *Config) { c.RoundHouses = -1 },
+		func(c *Config) { c.Houses = 0 },
+		func(c *Config) { c.HouseUses = -1 },
+		func(c *Config) { c.SweepInterval = "0s" },
+		func(c *Config) { c.RebalanceInterval = "soon" },
+		func(c *Config) { c.ShutdownTimeout = "0s" },
+		func(c *Config) { c.Fee.Policy = "free" },
+		func(c *Config) { c.Fee.Minimum = "0.1" },
+		func(c *Config) { c.Fee.Policy = "flat"; c.Fee.Flat = "0.5" },
+		func(c *Config) { c.Strategy.Kind = "random"; c.Strategy.MaxChunks = 1 },
+	}
+
+	for i, change := range tableTests {
+		t.Run(fmt.Sprintf("test %d", i), func(t *testing.T) {
+			config := defaultConfig()
+			change(&config)
+
+			testMixer := New(newTestLedger(t), store.NewMemory())
+			houses := testMixer.Wallet.Seeds
+			if err := testMixer.Configure(config); err == nil {
+				t.Errorf("record %d expected an error configuring %+v", i, config)
+			}
+			if len(testMixer.Wallet.Seeds) != len(houses) {
+				t.Errorf("record %d a rejected config must not change the mixer", i)
+			}
+		})
+	}
+}
+
+func TestLoadConfig(t *testing.T) {
+	dir, err := ioutil.TempDir("", "gtumbler-config")
+	if err != nil {
+		t.Fatalf("error creating temp dir: %s", err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "gtumbler-mixer.json")
+	err = ioutil.WriteFile(path, []byte(`{"Listen": ":9000", "MaxDeposit": "50", "Fee": {"Policy": "flat", "Flat": "0.01"}}`), 0644)
+	if err != nil {
+		t.Fatalf("error writing config file: %s", err)
+	}
+
+	config, err := LoadConfig(path)
+	if err != nil {
+		t.Fatalf("error loading config: %s", err)
+	}
+	if config.Listen != ":9000" || config.MaxDeposit != "50" || config.Fee.Policy != "flat" || config.Fee.Flat != "0.01" {
+		t.Errorf("unexpected config %+v", config)
+	}
+	// anything missing from the file keeps its default
+	if config.StorePath != "gtumbler-data" || config.MinDeposit != "0.1" || config.Fee.MaxPercent != 1 {
+		t.Errorf("expected fields missing from the file to keep their defaults, got %+v", config)
+	}
+
+	config, err = LoadConfig(filepath.Join(dir, "missing.json"))
+	if !os.IsNotExist(err) {
+		t.Errorf("expected a missing config file to be reported as such, got %v", err)
+	}
+	if !reflect.DeepEqual(config, defaultConfig()) {
+		t.Errorf("expected the defaults without a config file, got %+v", config)
+	}
+}
+
+func TestLoadConfigZero(t *testing.T) {
+	dir, err := ioutil.TempDir("", "gtumbler-config")
+	if err != nil {
+		t.Fatalf("error creating temp dir: %s", err)
+	}
+	defer os.RemoveAll(dir)
+
+	// zero is a meaningful setting for these, it must not be mistaken for a missing one
+	path := filepath.Join(dir, "gtumbler-mixer.json")
+	err = ioutil.WriteFile(path, []byte(`{"HouseUses": 0, "RoundHouses": 0, "Fee": {"Policy": "percent", "Percent": 0}}`), 0644)
+	if err != nil {
+		t.Fatalf("error writing config file: %s", err)
+	}
+
+	config, err := LoadConfig(path)
+	if err != nil {
+		t.Fatalf("error loading config: %s", err)
+	}
+	if config.HouseUses != 0 || config.RoundHouses != 0 || config.Fee.Percent != 0 {
+		t.Errorf("expected the zeros of the file to be kept, got %+v", config)
+	}
+
+	testMixer := New(newTestLedger(t), store.NewMemory())
+	if err := testMixer.Configure(config); err != nil {
+		t.Fatalf("error configuring mixer: %s", err)
+	}
+	if testMixer.Wallet.MaxUses != 0 || testMixer.Pool.Houses != 0 {
+		t.Errorf("unexpected wallet uses %d and round houses %d", testMixer.Wallet.MaxUses, testMixer.Pool.Houses)
+	}
+	if quote := testMixer.FeePolicy.Quote(crypto.MustParseAmount("1")); quote.Rate != 0 {
+		t.Errorf("expected no fee, got %+v", quote)
+	}
+}
@@ -0,0 +1,140 @@
+package mixer
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/Denton24646/gtumbler/pkg/crypto"
+	"github.com/Denton24646/gtumbler/pkg/mixer/tumbler"
+	"log"
+	"strconv"
+)
+
+// customersBucket is the store bucket holding one json encoded CustomerData per customer id
+const customersBucket = "customers"
+
+// errDuplicateCustomer is returned by createCustomer when the id is already taken
+var errDuplicateCustomer = errors.New("customer already exists")
+
+// customer returns a copy of the record for id
+func (m *Mixer) customer(id int) (CustomerData, bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	customer, ok := m.Customers[id]
+	return customer, ok
+}
+
+// createCustomer saves the record for a new customer, failing with errDuplicateCustomer if id is already in use
+func (m *Mixer) createCustomer(id int, customer CustomerData) error {
+	record, err := json.Marshal(customer)
+	if err != nil {
+		return err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, ok := m.Customers[id]; ok {
+		return errDuplicateCustomer
+	}
+	err = m.store.Put(customersBucket, strconv.Itoa(id), record)
+	if err != nil {
+		return err
+	}
+	m.Customers[id] = customer
+	return nil
+}
+
+// updateCustomer applies change to the current record for id and saves the result, all while holding the lock
+// so concurrent updates (the job itself, the deposit watcher and http handlers) never overwrite each other
+// Nothing is saved when change returns an error
+func (m *Mixer) updateCustomer(id int, change func(customer *CustomerData) error) (CustomerData, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	customer, ok := m.Customers[id]
+	if !ok {
+		return customer, fmt.Errorf("unknown customer %d", id)
+	}
+	// work on copies of the slices so a failed change leaves the cached record untouched
+	customer.Deposits = append([]crypto.Transaction(nil), customer.Deposits...)
+	customer.Refunds = append([]Refund(nil), customer.Refunds...)
+	customer.History = append([]Transition(nil), customer.History...)
+
+	err := change(&customer)
+	if err != nil {
+		return m.Customers[id], err
+	}
+
+	record, err := json.Marshal(customer)
+	if err != nil {
+		return m.Customers[id], err
+	}
+	err = m.store.Put(customersBucket, strconv.Itoa(id), record)
+	if err != nil {
+		return m.Customers[id], err
+	}
+	m.Customers[id] = customer
+	return customer, nil
+}
+
+// Resume loads every customer record from the store and restarts the unfinished ones in the background
+// It is called once on startup, before the mixer accepts new requests, and returns the ids of the resumed customers
+func (m *Mixer) Resume() ([]int, error) {
+	records, err := m.store.List(customersBucket)
+	if err != nil {
+		return nil, err
+	}
+
+	var resumed []int
+	for key, record := range records {
+		id, err := strconv.Atoi(key)
+		if err != nil {
+			log.Printf("skipping customer record with invalid id %q", key)
+			continue
+		}
+
+		customer := CustomerData{}
+		err = json.Unmarshal(record, &customer)
+		if err != nil {
+			return nil, err
+		}
+
+		m.mu.Lock()
+		m.Customers[id] = customer
+		m.mu.Unlock()
+
+		if !customer.State.Final() {
+			resumed = append(resumed, id)
+		}
+	}
+
+	for _, id := range resumed {
+		id := id
+		m.Go(func(ctx context.Context) {
+			err := m.HandleTransactionContext(ctx, id)
+			if err != nil && ctx.Err() == nil {
+				log.Printf("error resuming customer %d: %s", id, err)
+			}
+		})
+	}
+
+	return resumed, nil
+}
+
+// pending returns the planned transfers of every unfinished job that were not sent yet
+func (m *Mixer) pending() []tumbler.Transfer {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var pending []tumbler.Transfer
+	for _, customer := range m.Customers {
+		if customer.State.Final() || customer.Sent >= len(customer.Transfers) {
+			continue
+		}
+		pending = append(pending, customer.Transfers[customer.Sent:]...)
+	}
+	return pending
+}
@@ -0,0 +1,116 @@
+package mixer
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/Denton24646/gtumbler/pkg/crypto"
+	"github.com/Denton24646/gtumbler/pkg/models"
+	"log"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"time"
+)
+
+// feesBucket is the store bucket holding one json encoded FeeRecord per customer id
+const feesBucket = "fees"
+
+// feePrecision is the resolution of a fee rate, rates are applied as parts per million
+const feePrecision = 1000000
+
+// defaultFeeAddress is where collected fees are sent unless the mixer is configured otherwise
+const defaultFeeAddress = crypto.Address("HouseFees")
+
+// FeeRecord is the fee the house earned on a single job
+type FeeRecord struct {
+	Id      int            `json:"id"`
+	Amount  crypto.Amount  `json:"amount"`
+	Address crypto.Address `json:"address"`
+	At      time.Time      `json:"at"`
+}
+
+// FeesResponse is the body of GET /fees
+type FeesResponse struct {
+	Total crypto.Amount `json:"total"`
+	Fees  []FeeRecord   `json:"fees"`
+}
+
+// feeFor returns the fee charged on received at the given rate, rounded down to the nearest unit
+func feeFor(received crypto.Amount, rate float64) crypto.Amount {
+	return received.MulRatio(int64(math.Round(rate*feePrecision)), feePrecision)
+}
+
+// checkFee explains why a deposit cannot be paid out once its fee is taken off, or returns nil when it can
+// What is left has to be at least the smallest unit for every clean address
+func checkFee(quote Quote, received crypto.Amount, addresses int) error {
+	fee := quote.FeeFor(received)
+	left := received.Sub(fee)
+	if !left.IsPositive() || left.Units() < int64(addresses) {
+		return fmt.Errorf("a fee of %s leaves %s of the deposit of %s, too little to pay out", fee, left, received)
+	}
+	return nil
+}
+
+// recordFee adds the fee of a job to the fee ledger once the fee transfer went out
+func (m *Mixer) recordFee(id int, customer CustomerData) error {
+	if !customer.FeeAmount.IsPositive() {
+		return nil
+	}
+
+	record, err := json.Marshal(&FeeRecord{
+		Id:      id,
+		Amount:  customer.FeeAmount,
+		Address: m.FeeAddress,
+		At:      time.Now().UTC(),
+	})
+	if err != nil {
+		return err
+	}
+
+	log.Printf("**** Collected fee of %s from customer %d into %s", customer.FeeAmount, id, m.FeeAddress)
+	return m.store.Put(feesBucket, strconv.Itoa(id), record)
+}
+
+// FeeLedger returns every fee the house earned, oldest first, along with the total
+func (m *Mixer) FeeLedger() (*FeesResponse, error) {
+	records, err := m.store.List(feesBucket)
+	if err != nil {
+		return nil, err
+	}
+
+	response := &FeesResponse{
+		Total: crypto.Zero,
+		Fees:  []FeeRecord{},
+	}
+	for _, record := range records {
+		fee := FeeRecord{}
+		err := json.Unmarshal(record, &fee)
+		if err != nil {
+			return nil, err
+		}
+		response.Total = response.Total.Add(fee.Amount)
+		response.Fees = append(response.Fees, fee)
+	}
+	sort.Slice(response.Fees, func(i, j int) bool {
+		return response.Fees[i].At.Before(response.Fees[j].At)
+	})
+
+	return response, nil
+}
+
+// Fees is the GET /fees endpoint for operators of the mixer - it lists the fees earned per job
+func (m *Mixer) Fees(w http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodGet {
+		writeError(w, http.StatusMethodNotAllowed, models.ErrMethodNotAllowed, "use GET to list the fees")
+		return
+	}
+
+	response, err := m.FeeLedger()
+	if err != nil {
+		log.Printf("error reading fee ledger: %s", err)
+		writeError(w, http.StatusInternalServerError, models.ErrInternal, "error reading fee ledger")
+		return
+	}
+	writeJSON(w, http.StatusOK, response)
+}
@@ -0,0 +1,217 @@
+package mixer
+
+import (
+	"fmt"
+	"github.com/Denton24646/gtumbler/pkg/crypto"
+	"github.com/Denton24646/gtumbler/pkg/models"
+	"math/rand"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// FeePolicy decides what the mixer charges a customer
+// The policy is asked for a Quote when the customer creates a job, before any coins are deposited,
+// and the quote is what the customer is eventually charged regardless of later changes to the policy
+type FeePolicy interface {
+	// Quote returns the fee terms for a customer planning to deposit size, size is zero when the customer did not say
+	Quote(size crypto.Amount) Quote
+}
+
+// Quote is the fee terms a customer agreed to: a flat amount plus a fraction of the deposit, never less than Minimum
+// A tiered quote keeps its Tiers and the fraction is the rate of the tier the deposit that actually arrives falls in,
+// Rate is then only the rate for the size the customer said it would deposit
+type Quote struct {
+	Rate    float64       `json:"rate"`
+	Flat    crypto.Amount `json:"flat"`
+	Minimum crypto.Amount `json:"minimum"`
+	Tiers   []Tier        `json:"tiers,omitempty"`
+}
+
+// FeeFor returns the fee charged on a deposit of received coins, it never exceeds the deposit itself
+func (q Quote) FeeFor(received crypto.Amount) crypto.Amount {
+	fee := feeFor(received, q.rate(received)).Add(q.Flat)
+	if fee.Cmp(q.Minimum) < 0 {
+		fee = q.Minimum
+	}
+	if fee.Cmp(received) > 0 {
+		fee = received
+	}
+	return fee
+}
+
+// rate returns the fraction charged on a deposit of size
+func (q Quote) rate(size crypto.Amount) float64 {
+	if len(q.Tiers) == 0 {
+		return q.Rate
+	}
+	return tierRate(q.Tiers, size)
+}
+
+// wire converts the quote into what the client sees, including the fee for the size it plans to deposit
+func (q Quote) wire(size crypto.Amount) models.FeeQuote {
+	quote := models.FeeQuote{
+		Rate:     q.rate(size),
+		Flat:     q.Flat,
+		Minimum:  q.Minimum,
+		Estimate: crypto.Zero,
+	}
+	if size.IsPositive() {
+		quote.Estimate = q.FeeFor(size)
+	}
+	return quote
+}
+
+// FlatFee charges the same number of coins on every deposit
+type FlatFee struct {
+	Amount crypto.Amount
+}
+
+func (f FlatFee) Quote(size crypto.Amount) Quote {
+	return Quote{Flat: f.Amount}
+}
+
+// PercentFee charges a fixed fraction of every deposit, for example 0.01 for one percent
+type PercentFee struct {
+	Rate float64
+}
+
+func (p PercentFee) Quote(size crypto.Amount) Quote {
+	return Quote{Rate: p.Rate}
+}
+
+// Tier is the rate charged on deposits of at least From coins
+type Tier struct {
+	From crypto.Amount `json:"from"`
+	Rate float64       `json:"rate"`
+}
+
+// TieredFee charges a rate that depends on the size of the deposit, larger deposits usually get a lower rate
+// The rate is picked by what is actually deposited, the size the customer says it will deposit only sets the quoted
+// Rate and estimate. Customers that do not say are quoted the rate of the smallest tier
+type TieredFee struct {
+	Tiers []Tier
+}
+
+func (t TieredFee) Quote(size crypto.Amount) Quote {
+	tiers := append([]Tier(nil), t.Tiers...)
+	sort.Slice(tiers, func(i, j int) bool {
+		return tiers[i].From.Cmp(tiers[j].From) < 0
+	})
+	return Quote{Rate: tierRate(tiers, size), Tiers: tiers}
+}
+
+// tierRate returns the rate of the highest tier size reaches, or of the lowest tier when it reaches none
+// The tiers have to be sorted by From
+func tierRate(tiers []Tier, size crypto.Amount) float64 {
+	rate := 0.0
+	for i, tier := range tiers {
+		if i == 0 || size.Cmp(tier.From) >= 0 {
+			rate = tier.Rate
+		}
+	}
+	return rate
+}
+
+// RandomFee charges a rate picked at random between Min and Max for every customer
+// Varying the fee means the payout amount cannot be derived from the deposit amount, defeating amount correlation
+type RandomFee struct {
+	Min float64
+	Max float64
+}
+
+func (r RandomFee) Quote(size crypto.Amount) Quote {
+	return Quote{Rate: r.Min + rand.Float64()*(r.Max-r.Min)}
+}
+
+// MinimumFee puts a floor under the fee of another policy
+type MinimumFee struct {
+	Policy  FeePolicy
+	Minimum crypto.Amount
+}
+
+func (m MinimumFee) Quote(size crypto.Amount) Quote {
+	quote := m.Policy.Quote(size)
+	if quote.Minimum.Cmp(m.Minimum) < 0 {
+		quote.Minimum = m.Minimum
+	}
+	return quote
+}
+
+// FeeConfig selects and configures a fee policy, percentages are given in percent (0.5 is half a percent)
+type FeeConfig struct {
+	// Policy is one of flat, percent, tiered or random
+	Policy string `cfgDefault:"random"`
+	// Flat is the fee in coins of the flat policy
+	Flat string `cfgDefault:"0"`
+	// Percent is the rate of the percent policy
+	Percent float64 `cfgDefault:"0.5"`
+	// MinPercent and MaxPercent bound the rate of the random policy
+	MinPercent float64 `cfgDefault:"0"`
+	MaxPercent float64 `cfgDefault:"1"`
+	// Tiers is the list of tiers of the tiered policy as "from:percent" pairs, for example "0:1,1:0.5,5:0.25"
+	Tiers string `cfgDefault:"0:1,1:0.5,5:0.25"`
+	// Minimum is the fee floor in coins applied to every policy
+	Minimum string `cfgDefault:"0"`
+}
+
+// NewFeePolicy builds the fee policy described by config
+func NewFeePolicy(config FeeConfig) (FeePolicy, error) {
+	var policy FeePolicy
+	switch config.Policy {
+	case "flat":
+		amount, err := crypto.ParseAmount(config.Flat)
+		if err != nil {
+			return nil, fmt.Errorf("flat fee: %s", err)
+		}
+		policy = FlatFee{Amount: amount}
+	case "percent":
+		policy = PercentFee{Rate: config.Percent / 100}
+	case "tiered":
+		tiers, err := parseTiers(config.Tiers)
+		if err != nil {
+			return nil, err
+		}
+		policy = TieredFee{Tiers: tiers}
+	case "random":
+		if config.MinPercent > config.MaxPercent {
+			return nil, fmt.Errorf("random fee: minimum %f%% is above maximum %f%%", config.MinPercent, config.MaxPercent)
+		}
+		policy = RandomFee{Min: config.MinPercent / 100, Max: config.MaxPercent / 100}
+	default:
+		return nil, fmt.Errorf("unknown fee policy %q, use flat, percent, tiered or random", config.Policy)
+	}
+
+	if config.Minimum == "" {
+		return policy, nil
+	}
+	minimum, err := crypto.ParseAmount(config.Minimum)
+	if err != nil {
+		return nil, fmt.Errorf("minimum fee: %s", err)
+	}
+	if minimum.IsPositive() {
+		policy = MinimumFee{Policy: policy, Minimum: minimum}
+	}
+	return policy, nil
+}
+
+// parseTiers parses "from:percent" pairs separated by commas
+func parseTiers(raw string) ([]Tier, error) {
+	var tiers []Tier
+	for _, pair := range strings.Split(raw, ",") {
+		parts := strings.Split(strings.TrimSpace(pair), ":")
+		if len(parts) != 2 {
+			return nil, fmt.Errorf("tier %q must look like from:percent", pair)
+		}
+		from, err := crypto.ParseAmount(parts[0])
+		if err != nil {
+			return nil, fmt.Errorf("tier %q: %s", pair, err)
+		}
+		percent, err := strconv.ParseFloat(parts[1], 64)
+		if err != nil {
+			return nil, fmt.Errorf("tier %q: %s", pair, err)
+		}
+		tiers = append(tiers, Tier{From: from, Rate: percent / 100})
+	}
+	return tiers, nil
+}
@@ -0,0 +1,84 @@
+package mixer
+
+import (
+	"fmt"
+	"github.com/Denton24646/gtumbler/pkg/crypto"
+	"testing"
+)
+
+func TestQuote_FeeFor(t *testing.T) {
+	tableTests := []struct {
+		policy   FeePolicy
+		size     string
+		received string
+		fee      string
+	}{
+		{FlatFee{Amount: crypto.MustParseAmount("0.01")}, "0", "4", "0.01"},
+		{PercentFee{Rate: 0.01}, "0", "4", "0.04"},
+		{TieredFee{Tiers: []Tier{{crypto.MustParseAmount("5"), 0.0025}, {crypto.Zero, 0.01}, {crypto.MustParseAmount("1"), 0.005}}}, "0", "4", "0.02"},
+		{TieredFee{Tiers: []Tier{{crypto.Zero, 0.01}, {crypto.MustParseAmount("1"), 0.005}, {crypto.MustParseAmount("5"), 0.0025}}}, "4", "4", "0.02"},
+		{TieredFee{Tiers: []Tier{{crypto.Zero, 0.01}, {crypto.MustParseAmount("1"), 0.005}, {crypto.MustParseAmount("5"), 0.0025}}}, "8", "8", "0.02"},
+		// the tier is picked by what arrives, not by what the customer said it would deposit
+		{TieredFee{Tiers: []Tier{{crypto.Zero, 0.01}, {crypto.MustParseAmount("1"), 0.005}, {crypto.MustParseAmount("5"), 0.0025}}}, "9", "0.5", "0.005"},
+		{TieredFee{Tiers: []Tier{{crypto.Zero, 0.01}, {crypto.MustParseAmount("1"), 0.005}, {crypto.MustParseAmount("5"), 0.0025}}}, "0.5", "8", "0.02"},
+		{MinimumFee{Policy: PercentFee{Rate: 0.01}, Minimum: crypto.MustParseAmount("0.1")}, "0", "4", "0.1"},
+		{MinimumFee{Policy: PercentFee{Rate: 0.01}, Minimum: crypto.MustParseAmount("0.1")}, "0", "20", "0.2"},
+		// the fee never takes more than the deposit
+		{FlatFee{Amount: crypto.MustParseAmount("1")}, "0", "0.5", "0.5"},
+	}
+
+	for i, tt := range tableTests {
+		t.Run(fmt.Sprintf("test %d", i), func(t *testing.T) {
+			quote := tt.policy.Quote(crypto.MustParseAmount(tt.size))
+			fee := quote.FeeFor(crypto.MustParseAmount(tt.received))
+			if fee != crypto.MustParseAmount(tt.fee) {
+				t.Errorf("record %d got fee %s, want %s", i, fee, tt.fee)
+			}
+		})
+	}
+}
+
+func TestRandomFee_Quote(t *testing.T) {
+	policy := RandomFee{Min: 0.002, Max: 0.01}
+	for i := 0; i < 100; i++ {
+		quote := policy.Quote(crypto.Zero)
+		if quote.Rate < policy.Min || quote.Rate > policy.Max {
+			t.Fatalf("expected a rate between %f and %f, got %f", policy.Min, policy.Max, quote.Rate)
+		}
+	}
+}
+
+func TestNewFeePolicy(t *testing.T) {
+	tableTests := []struct {
+		config FeeConfig
+		fee    string
+		valid  bool
+	}{
+		{FeeConfig{Policy: "flat", Flat: "0.01"}, "0.01", true},
+		{FeeConfig{Policy: "percent", Percent: 0.5}, "0.02", true},
+		{FeeConfig{Policy: "tiered", Tiers: "0:1,1:0.5,5:0.25"}, "0.02", true},
+		{FeeConfig{Policy: "random", MinPercent: 1, MaxPercent: 1}, "0.04", true},
+		{FeeConfig{Policy: "percent", Percent: 0.5, Minimum: "0.1"}, "0.1", true},
+		{FeeConfig{Policy: "flat", Flat: "lots"}, "", false},
+		{FeeConfig{Policy: "tiered", Tiers: "0:1,1"}, "", false},
+		{FeeConfig{Policy: "random", MinPercent: 2, MaxPercent: 1}, "", false},
+		{FeeConfig{Policy: "percent", Minimum: "-"}, "", false},
+		{FeeConfig{Policy: "free"}, "", false},
+	}
+
+	for i, tt := range tableTests {
+		t.Run(fmt.Sprintf("test %d", i), func(t *testing.T) {
+			policy, err := NewFeePolicy(tt.config)
+			if (err == nil) != tt.valid {
+				t.Fatalf("record %d got error %v, want valid %v", i, err, tt.valid)
+			}
+			if !tt.valid {
+				return
+			}
+			size := crypto.MustParseAmount("4")
+			if fee := policy.Quote(size).FeeFor(size); fee != crypto.MustParseAmount(tt.fee) {
+				t.Errorf("record %d got fee %s, want %s", i, fee, tt.fee)
+			}
+		})
+	}
+}
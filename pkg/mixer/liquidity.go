@@ -0,0 +1,276 @@
+package mixer
+
+import (
+	"context"
+	"fmt"
+	"github.com/Denton24646/gtumbler/pkg/crypto"
+	"github.com/Denton24646/gtumbler/pkg/mixer/tumbler"
+	"github.com/Denton24646/gtumbler/pkg/models"
+	"log"
+	"net/http"
+	"sort"
+	"time"
+)
+
+// defaultRebalanceInterval is how often house balances are measured and evened out
+const defaultRebalanceInterval = 10 * time.Minute
+
+// rebalanceTolerance is how far, in percent of the average, a house may stray from the average before it is rebalanced
+const rebalanceTolerance = 20
+
+// HouseLiquidity is what a single house address holds and can pay out
+type HouseLiquidity struct {
+	Address crypto.Address `json:"address"`
+	// Balance is what the ledger reports, Available adds what pending transfers move in and takes out what they move out
+	Balance   crypto.Amount `json:"balance"`
+	Available crypto.Amount `json:"available"`
+}
+
+// Liquidity is the body of GET /liquidity, a snapshot of what the active house addresses hold
+// Reserved is what jobs still waiting for their deposit asked to mix, Free is what is left for new jobs
+type Liquidity struct {
+	Houses    []HouseLiquidity `json:"houses"`
+	Total     crypto.Amount    `json:"total"`
+	Available crypto.Amount    `json:"available"`
+	Reserved  crypto.Amount    `json:"reserved"`
+	Free      crypto.Amount    `json:"free"`
+	Measured  time.Time        `json:"measured"`
+}
+
+// measureLiquidity reads the balance of every active house and accounts for the transfers still waiting to be sent
+func (m *Mixer) measureLiquidity(ctx context.Context) (Liquidity, error) {
+	houses, err := m.Wallet.Active()
+	if err != nil {
+		return Liquidity{}, err
+	}
+
+	liquidity := Liquidity{
+		Houses:    []HouseLiquidity{},
+		Total:     crypto.Zero,
+		Available: crypto.Zero,
+		Measured:  time.Now().UTC(),
+	}
+	index := make(map[crypto.Address]int)
+	for _, house := range houses {
+		balance, err := m.ledger.BalanceContext(ctx, house)
+		if err != nil {
+			return Liquidity{}, err
+		}
+		index[house] = len(liquidity.Houses)
+		liquidity.Houses = append(liquidity.Houses, HouseLiquidity{Address: house, Balance: balance, Available: balance})
+		liquidity.Total = liquidity.Total.Add(balance)
+	}
+
+	for _, transfer := range m.pending() {
+		if i, ok := index[transfer.To]; ok {
+			liquidity.Houses[i].Available = liquidity.Houses[i].Available.Add(transfer.Amount)
+		}
+		if i, ok := index[transfer.From]; ok {
+			liquidity.Houses[i].Available = liquidity.Houses[i].Available.Sub(transfer.Amount)
+		}
+	}
+	for _, house := range liquidity.Houses {
+		if house.Available.IsPositive() {
+			liquidity.Available = liquidity.Available.Add(house.Available)
+		}
+	}
+	return liquidity, nil
+}
+
+// reserved adds up the sizes of the jobs still waiting for their deposit
+func (m *Mixer) reserved() crypto.Amount {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	reserved := crypto.Zero
+	for _, customer := range m.Customers {
+		if customer.State == AwaitingDeposit {
+			reserved = reserved.Add(customer.Size)
+		}
+	}
+	return reserved
+}
+
+// refreshLiquidity measures the house addresses and keeps the result for Create and GET /liquidity
+func (m *Mixer) refreshLiquidity(ctx context.Context) (Liquidity, error) {
+	liquidity, err := m.measureLiquidity(ctx)
+	if err != nil {
+		return liquidity, err
+	}
+
+	m.liquidityMu.Lock()
+	defer m.liquidityMu.Unlock()
+	m.liquidity = &liquidity
+	return liquidity, nil
+}
+
+// currentLiquidity returns the last measurement, measuring the house addresses first if they never were
+// Reserved and Free are always worked out from the jobs as they are now
+func (m *Mixer) currentLiquidity(ctx context.Context) (Liquidity, error) {
+	m.liquidityMu.Lock()
+	last := m.liquidity
+	m.liquidityMu.Unlock()
+
+	var liquidity Liquidity
+	if last == nil {
+		var err error
+		liquidity, err = m.refreshLiquidity(ctx)
+		if err != nil {
+			return liquidity, err
+		}
+	} else {
+		liquidity = *last
+	}
+
+	liquidity.Reserved = m.reserved()
+	liquidity.Free = crypto.Zero
+	if liquidity.Available.Cmp(liquidity.Reserved) > 0 {
+		liquidity.Free = liquidity.Available.Sub(liquidity.Reserved)
+	}
+	return liquidity, nil
+}
+
+// WatchLiquidity rebalances the house addresses and measures them again every interval until ctx is done
+func (m *Mixer) WatchLiquidity(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		err := m.rebalance(ctx)
+		if err != nil {
+			log.Printf("error rebalancing house addresses: %s", err)
+		}
+		_, err = m.refreshLiquidity(ctx)
+		if err != nil {
+			log.Printf("error measuring house liquidity: %s", err)
+		}
+	}
+}
+
+// rebalance evens out what the active house addresses can pay out, so payouts rarely have to be split or drawn from
+// outside their round, and freshly generated houses get funded
+// Coins only move out of houses that are more than rebalanceTolerance above the average, into houses more than
+// rebalanceTolerance below it, and never out of what pending transfers still have to send from a house
+// Plans are held off meanwhile so no new plan counts on coins that are being moved
+func (m *Mixer) rebalance(ctx context.Context) error {
+	m.planMu.Lock()
+	defer m.planMu.Unlock()
+
+	liquidity, err := m.measureLiquidity(ctx)
+	if err != nil || len(liquidity.Houses) < 2 {
+		return err
+	}
+
+	// what each house can give away right now: its balance less the pending sends out of it
+	spendable := make(map[crypto.Address]crypto.Amount)
+	for _, house := range liquidity.Houses {
+		spendable[house.Address] = house.Balance
+	}
+	for _, transfer := range m.pending() {
+		if balance, ok := spendable[transfer.From]; ok {
+			spendable[transfer.From] = balance.Sub(transfer.Amount)
+		}
+	}
+
+	average := liquidity.Available.MulRatio(1, int64(len(liquidity.Houses)))
+	margin := average.MulRatio(rebalanceTolerance, 100)
+	var surplus, deficit []HouseLiquidity
+	for _, house := range liquidity.Houses {
+		switch {
+		case house.Available.Cmp(average.Add(margin)) > 0:
+			surplus = append(surplus, house)
+		case house.Available.Cmp(average.Sub(margin)) < 0:
+			deficit = append(deficit, house)
+		}
+	}
+	// the fullest houses give to the emptiest ones first
+	sort.Slice(surplus, func(i, j int) bool { return surplus[i].Available.Cmp(surplus[j].Available) > 0 })
+	sort.Slice(deficit, func(i, j int) bool { return deficit[i].Available.Cmp(deficit[j].Available) < 0 })
+
+	// no house is moved through more transactions than it has left before it is retired
+	uses, err := m.Wallet.Remaining()
+	if err != nil {
+		return err
+	}
+	usable := func(house crypto.Address) bool {
+		left, limited := uses[house]
+		return !limited || left > 0
+	}
+
+	var moved []tumbler.Transfer
+	for _, from := range surplus {
+		excess := from.Available.Sub(average)
+		if spendable[from.Address].Cmp(excess) < 0 {
+			excess = spendable[from.Address]
+		}
+		for i := range deficit {
+			if !excess.IsPositive() || !usable(from.Address) {
+				break
+			}
+			need := average.Sub(deficit[i].Available)
+			if !need.IsPositive() || !usable(deficit[i].Address) {
+				continue
+			}
+			amount := need
+			if excess.Cmp(amount) < 0 {
+				amount = excess
+			}
+			err := m.ledger.SendContext(ctx, from.Address, deficit[i].Address, amount)
+			if err != nil {
+				if useErr := m.Wallet.Use(moved); useErr != nil {
+					log.Printf("error counting the house addresses used by rebalancing: %s", useErr)
+				}
+				return err
+			}
+			log.Printf("**** Rebalanced %s coins from house address %s into %s", amount, from.Address, deficit[i].Address)
+			moved = append(moved, tumbler.Transfer{From: from.Address, To: deficit[i].Address, Amount: amount})
+			for _, house := range []crypto.Address{from.Address, deficit[i].Address} {
+				if left, limited := uses[house]; limited {
+					uses[house] = left - 1
+				}
+			}
+			deficit[i].Available = deficit[i].Available.Add(amount)
+			excess = excess.Sub(amount)
+		}
+	}
+	return m.Wallet.Use(moved)
+}
+
+// checkLiquidity reports a /create request the house addresses cannot pay out on top of the jobs already waiting
+// A request without a size is not checked, the payout is checked again before any coins move either way
+func (m *Mixer) checkLiquidity(ctx context.Context, request *models.CleanAddressRequest) (*validationError, error) {
+	if !request.Size.IsPositive() {
+		return nil, nil
+	}
+	liquidity, err := m.currentLiquidity(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if request.Size.Cmp(liquidity.Free) > 0 {
+		return &validationError{http.StatusServiceUnavailable, models.ErrInsufficientLiquidity,
+			fmt.Sprintf("the mixer cannot take on a deposit of %s right now, at most %s is available",
+				request.Size, liquidity.Free)}, nil
+	}
+	return nil, nil
+}
+
+// Liquidity is the GET /liquidity endpoint for operators of the mixer - it reports what the house addresses hold
+func (m *Mixer) Liquidity(w http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodGet {
+		writeError(w, http.StatusMethodNotAllowed, models.ErrMethodNotAllowed, "use GET to check the liquidity of the mixer")
+		return
+	}
+
+	liquidity, err := m.currentLiquidity(req.Context())
+	if err != nil {
+		log.Printf("error measuring house liquidity: %s", err)
+		writeError(w, http.StatusInternalServerError, models.ErrInternal, "error measuring house liquidity")
+		return
+	}
+	writeJSON(w, http.StatusOK, liquidity)
+}
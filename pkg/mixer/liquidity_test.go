@@ -0,0 +1,99 @@
+package mixer
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/Denton24646/gtumbler/pkg/crypto"
+	"github.com/Denton24646/gtumbler/pkg/mixer/tumbler"
+	"github.com/Denton24646/gtumbler/pkg/store"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestMixer_Rebalance(t *testing.T) {
+	ledger := newTestLedger(t)
+	testMixer := New(ledger, store.NewMemory())
+	testMixer.Wallet = NewWallet(ledger, store.NewMemory(), []crypto.Address{"House1", "House2"})
+	testMixer.Wallet.Size = 3
+
+	// another job still has to pay out of House1
+	err := testMixer.saveCustomer(4, CustomerData{
+		DepositAddress: "OtherDeposit",
+		Transfers:      []tumbler.Transfer{{From: "House1", To: "Other", Amount: crypto.MustParseAmount("4")}},
+		State:          PayingOut,
+	})
+	if err != nil {
+		t.Fatalf("error saving customer: %s", err)
+	}
+
+	before, err := testMixer.measureLiquidity(context.Background())
+	if err != nil {
+		t.Fatalf("error measuring liquidity: %s", err)
+	}
+	if len(before.Houses) != 3 || before.Total != crypto.MustParseAmount("20") || before.Available != crypto.MustParseAmount("16") {
+		t.Fatalf("expected 16 of 20 coins available over 3 houses, got %+v", before)
+	}
+
+	if err := testMixer.rebalance(context.Background()); err != nil {
+		t.Fatalf("error rebalancing: %s", err)
+	}
+	after, err := testMixer.measureLiquidity(context.Background())
+	if err != nil {
+		t.Fatalf("error measuring liquidity: %s", err)
+	}
+	if after.Total != before.Total || after.Available != before.Available {
+		t.Errorf("rebalancing must not change the totals, got %s and %s", after.Total, after.Available)
+	}
+	average := after.Available.MulRatio(1, 3)
+	margin := average.MulRatio(rebalanceTolerance, 100)
+	for _, house := range after.Houses {
+		if house.Available.Cmp(average.Add(margin)) > 0 || house.Available.Cmp(average.Sub(margin)) < 0 {
+			t.Errorf("expected %s to be within %s of %s, it has %s available", house.Address, margin, average, house.Available)
+		}
+	}
+	// the coins the pending payout needs stay in House1
+	if balance, _ := ledger.Balance("House1"); balance.Cmp(crypto.MustParseAmount("4")) < 0 {
+		t.Errorf("expected House1 to keep at least 4 coins for the pending payout, got %s", balance)
+	}
+}
+
+func TestMixer_CreateLiquidity(t *testing.T) {
+	testMixer := New(newTestLedger(t), store.NewMemory())
+
+	// the five houses hold 50 coins, jobs waiting for their deposit hold on to what they asked for
+	tableTests := []struct {
+		size   string
+		status int
+	}{
+		{"30", http.StatusOK},
+		{"15", http.StatusOK},
+		{"10", http.StatusServiceUnavailable},
+		{"5", http.StatusOK},
+	}
+
+	for i, tt := range tableTests {
+		recorder := httptest.NewRecorder()
+		body := fmt.Sprintf(`{"id": %d, "addresses": ["Clean1"], "size": "%s"}`, i+1, tt.size)
+		testMixer.Create(recorder, httptest.NewRequest(http.MethodPost, "/create", bytes.NewBufferString(body)))
+		if recorder.Code != tt.status {
+			t.Errorf("record %d got status %d, want %d: %s", i, recorder.Code, tt.status, recorder.Body)
+		}
+	}
+
+	recorder := httptest.NewRecorder()
+	testMixer.Liquidity(recorder, httptest.NewRequest(http.MethodGet, "/liquidity", nil))
+	if recorder.Code != http.StatusOK {
+		t.Fatalf("expected status 200, got %d", recorder.Code)
+	}
+	liquidity := &Liquidity{}
+	if err := json.Unmarshal(recorder.Body.Bytes(), liquidity); err != nil {
+		t.Fatalf("error parsing response: %s", err)
+	}
+	if liquidity.Available != crypto.MustParseAmount("50") || liquidity.Reserved != crypto.MustParseAmount("50") ||
+		!liquidity.Free.IsZero() || len(liquidity.Houses) != 5 {
+		t.Errorf("expected all 50 coins of the 5 houses to be reserved, got %+v", liquidity)
+	}
+}
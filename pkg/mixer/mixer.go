@@ -1,17 +1,33 @@
 package mixer
 
 import (
+	"context"
 	"encoding/json"
+	"fmt"
 	"github.com/Denton24646/gtumbler/pkg/crypto"
 	"github.com/Denton24646/gtumbler/pkg/mixer/tumbler"
 	"github.com/Denton24646/gtumbler/pkg/models"
+	"github.com/Denton24646/gtumbler/pkg/store"
 	"io/ioutil"
 	"log"
-	"math/rand"
 	"net/http"
+	"sync"
 	"time"
 )
 
+// defaultPollInterval is how often deposit addresses are checked for new deposits
+const defaultPollInterval = 10 * time.Second
+
+// defaultDepositWindow is how long a new job waits for its deposit before it is cancelled
+const defaultDepositWindow = 24 * time.Hour
+
+// defaultWatchWindow is how long the deposit address of a finished job is still watched for stray deposits
+const defaultWatchWindow = 7 * 24 * time.Hour
+
+// defaultDelay is how long sends are spread over for customers that do not choose, maxDelay is the longest they can choose
+const defaultDelay = 10 * time.Minute
+const maxDelay = 24 * time.Hour
+
 // The mixer is an http server responsible for mixing the client coins by doing the following
 // 1. On startup, preseed a certain amount of addresses with coins (to bootstrap the mixing process)
 // Since there is no API method for creating coins from scratch, these addresses need to be made from the UI
@@ -28,89 +44,237 @@ type Server interface {
 	//CreateDepositAddress generates a new deposit address for the customer
 	generateCustomerDepositAddress() (crypto.Address, error)
 	//PollDepositAddress checks the deposit address periodically to see if the client deposited funds
-	PollDepositAddress(address crypto.Address) (crypto.Amount, error)
+	PollDepositAddress(address crypto.Address, known int) ([]crypto.Transaction, error)
+	PollDepositAddressContext(ctx context.Context, address crypto.Address, known int) ([]crypto.Transaction, error)
 	// HandleTransaction is responsible for all the backend work of the mixer service
 	HandleTransaction(id int) error
+	// HandleTransactionContext is HandleTransaction, but stops the work once ctx is done
+	HandleTransactionContext(ctx context.Context, id int) error
+	// Status is the /status/{id} endpoint for the mixer - it reports the state of a customer job
+	Status(w http.ResponseWriter, req *http.Request)
+	// Cancel is the /cancel/{id} endpoint for the mixer - it cancels a job that did not receive its deposit yet
+	Cancel(w http.ResponseWriter, req *http.Request)
 }
 
 type Mixer struct {
 	// Customer Ids is an map of Ids of clients to their ultimate clean addresses and deposit information
-	// It's an in memory datastore for the purposes of having access to customer information
+	// It's an in memory copy of the customer records kept in store, guarded by mu
 	// TODO these ids would be used to further obfuscate in the mixing process
-	// TODO use more durable datastore
 	Customers map[int]CustomerData
-	// house addresses is an array of addresses the house owns and are already funded
+	mu        sync.Mutex
+	// refundMu makes sure only one refund is being sent at a time
+	refundMu sync.Mutex
+	// planMu makes sure only one job is planned at a time, so each plan sees the transfers of the ones before it
+	planMu sync.Mutex
+	// liquidity is the last measurement of the house addresses, guarded by liquidityMu
+	liquidity   *Liquidity
+	liquidityMu sync.Mutex
+	// work counts the jobs and watchers running in the background (see Go), their context is cancelled by stop
+	// draining is set under mu once Shutdown started, from then on nothing new is started
+	work     sync.WaitGroup
+	workCtx  context.Context
+	stop     context.CancelFunc
+	draining bool
+	// store durably keeps every customer record so in-flight jobs survive a restart of the mixer
+	store store.Store
+	// Wallet keeps the house addresses, generating fresh ones and retiring those used too often
 	// these addresses can be used by the tumbler, which has no knowledge of the mixer and simply moves coins around
-	HouseAddresses []crypto.Address
+	Wallet *Wallet
+	// FeeAddress is the house address collected fees are sent to
+	FeeAddress crypto.Address
+	// FeePolicy decides the fee quoted to every new customer
+	FeePolicy FeePolicy
+	// Strategy decides how the tumbler chunks up deposits and payouts
+	Strategy tumbler.Strategy
+	// Pool batches customers into mixing rounds sharing the same house addresses
+	Pool *Pool
+	// Limits bounds the deposits the mixer accepts, anything outside them is refunded
+	Limits tumbler.Limits
+	// PollInterval is how often deposit addresses are checked for new deposits, and jobs tried again while the ledger is down
+	// DepositWindow is how long a new job waits for its deposit before it is cancelled
+	// WatchInterval is how often deposit addresses of finished jobs are checked for stray deposits
+	// WatchWindow is how long after it finished a job is still checked for stray deposits
+	// SweepInterval is how often retired house addresses are swept
+	// RebalanceInterval is how often house addresses are measured and rebalanced
+	PollInterval      time.Duration
+	DepositWindow     time.Duration
+	WatchInterval     time.Duration
+	WatchWindow       time.Duration
+	SweepInterval     time.Duration
+	RebalanceInterval time.Duration
+	// DefaultDelay is the window sends are randomly spread over when the customer does not choose one
+	// MaxDelay is the longest window a customer can choose
+	DefaultDelay time.Duration
+	MaxDelay     time.Duration
+	// ShutdownTimeout is how long Shutdown waits for running jobs to stop
+	ShutdownTimeout time.Duration
+	// ledger is the coin network the mixer watches deposits on and moves funds through
+	ledger crypto.Ledger
 }
 
 type CustomerData struct {
-	CleanAddresses  []crypto.Address
-	DepositAddress  crypto.Address
-	Fee             float64
+	CleanAddresses []crypto.Address
+	// Percentages is the share of the payout each clean address gets, when nil the shares are random
+	Percentages    []float64
+	DepositAddress crypto.Address
+	// MaxDelay is the window every send of the job is randomly spread over, from its deposit to its last payout
+	MaxDelay time.Duration
+	// Round is the mixing round the job was pooled into
+	Round int
+	// Size is how much the customer said it would deposit, it is counted against the house liquidity until the deposit arrives
+	Size crypto.Amount
+	// Quote is the fee the customer was quoted up front, when the job was created
+	// FeeAmount is the fee in coins, deducted from the deposit before the payout
+	Quote     Quote
+	FeeAmount crypto.Amount
+	// Deposits are the incoming transactions seen on the deposit address and Received is their total
+	Deposits []crypto.Transaction
+	Received crypto.Amount
+	// Transfers is the full tumbling plan: the first Mixed transfers move the deposit into house addresses
+	// and the rest pay out to the clean addresses. Sent is how many of them completed
+	Transfers []tumbler.Transfer
+	Mixed     int
+	Sent      int
+	// Sending marks the transfer being sent when the job stopped, it is only sent again if it did not go through
+	Sending *tumbler.Sending
+	// Refunds are deposits sent back to their sender, either because they were outside the mixer guidelines
+	// or because they arrived after the job stopped accepting deposits
+	Refunds []Refund
+	// State is where the job is in the mixing process and History records every state it went through
+	State   State
+	History []Transition
 }
 
-func New() *Mixer {
+func New(ledger crypto.Ledger, store store.Store) *Mixer {
+	workCtx, stop := context.WithCancel(context.Background())
 	return &Mixer{
-		Customers: make(map[int]CustomerData),
-		HouseAddresses: []crypto.Address{
+		workCtx:           workCtx,
+		stop:              stop,
+		ShutdownTimeout:   defaultShutdownTimeout,
+		ledger:            ledger,
+		store:             store,
+		FeeAddress:        defaultFeeAddress,
+		FeePolicy:         RandomFee{Min: 0, Max: 0.01},
+		Strategy:          tumbler.NewFixedStrategy(),
+		Pool:              NewPool(store),
+		Limits:            tumbler.DefaultLimits,
+		PollInterval:      defaultPollInterval,
+		DepositWindow:     defaultDepositWindow,
+		WatchInterval:     defaultPollInterval,
+		WatchWindow:       defaultWatchWindow,
+		DefaultDelay:      defaultDelay,
+		MaxDelay:          maxDelay,
+		SweepInterval:     defaultSweepInterval,
+		RebalanceInterval: defaultRebalanceInterval,
+		Customers:         make(map[int]CustomerData),
+		Wallet: NewWallet(ledger, store, []crypto.Address{
 			0: "House1",
 			1: "House2",
 			2: "House3",
 			3: "House4",
 			4: "House5",
-		},
+		}),
 	}
 }
 
 func (m *Mixer) Create(w http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodPost {
+		writeError(w, http.StatusMethodNotAllowed, models.ErrMethodNotAllowed, "use POST to create a mixing request")
+		return
+	}
+
 	request := &models.CleanAddressRequest{}
 
 	body, err := ioutil.ReadAll(req.Body)
 	if err != nil {
+		writeError(w, http.StatusBadRequest, models.ErrInvalidJSON, "error reading request body")
 		return
 	}
 	defer req.Body.Close()
 
 	err = json.Unmarshal(body, &request)
 	if err != nil {
+		writeError(w, http.StatusBadRequest, models.ErrInvalidJSON, fmt.Sprintf("request is not valid json: %s", err))
+		return
+	}
+
+	if m.stopping() {
+		writeError(w, http.StatusServiceUnavailable, models.ErrShuttingDown, "the mixer is shutting down, try again later")
+		return
+	}
+
+	if invalid := validateRequest(request); invalid != nil {
+		writeError(w, invalid.status, invalid.code, invalid.msg)
+		return
+	}
+	delay, invalid := m.delayFor(request)
+	if invalid != nil {
+		writeError(w, invalid.status, invalid.code, invalid.msg)
+		return
+	}
+	invalid, err = m.checkLiquidity(req.Context(), request)
+	if err != nil {
+		log.Printf("error checking house liquidity: %s", err)
+		writeError(w, http.StatusInternalServerError, models.ErrInternal, "error checking house liquidity")
+		return
+	}
+	if invalid != nil {
+		writeError(w, invalid.status, invalid.code, invalid.msg)
 		return
 	}
 
 	depositAddress, err := m.generateCustomerDepositAddress()
 	if err != nil {
+		log.Printf("error generating deposit address: %s", err)
+		writeError(w, http.StatusInternalServerError, models.ErrInternal, "error generating deposit address")
 		return
 	}
 
 	customerId := request.Id
-	m.Customers[customerId] = CustomerData{
+	customer := CustomerData{
 		CleanAddresses: request.Addresses,
+		Percentages:    request.Percentages,
 		DepositAddress: depositAddress,
-		Fee: rand.Float64() * 0.01,
+		MaxDelay:       delay,
+		Size:           request.Size,
+		Quote:          m.FeePolicy.Quote(request.Size),
 	}
-
-	response := &models.CleanAddressResponse{
-		DepositAddress: depositAddress,
+	err = customer.transition(AwaitingDeposit, "")
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, models.ErrInternal, err.Error())
+		return
+	}
+	err = m.createCustomer(customerId, customer)
+	if err == errDuplicateCustomer {
+		writeError(w, http.StatusConflict, models.ErrDuplicateId, fmt.Sprintf("customer id %d is already in use", customerId))
+		return
 	}
-	res,_ := json.Marshal(response)
-
-	_, err = w.Write(res)
 	if err != nil {
+		log.Printf("error saving customer %d: %s", customerId, err)
+		writeError(w, http.StatusInternalServerError, models.ErrInternal, "error saving customer")
 		return
 	}
 
-	// concurrently handle customer transactions via goroutines
-	go func(id int) {
-		err := m.HandleTransaction(id)
-		if err != nil {
-			return
+	response := &models.CleanAddressResponse{
+		DepositAddress: depositAddress,
+		Fee:            customer.Quote.wire(request.Size),
+		MaxDelay:       int64(delay / time.Second),
+	}
+	writeJSON(w, http.StatusOK, response)
+
+	// concurrently handle customer transactions via goroutines, until the mixer shuts down
+	id := customerId
+	m.Go(func(ctx context.Context) {
+		err := m.HandleTransactionContext(ctx, id)
+		if err != nil && ctx.Err() == nil {
+			log.Printf("error handling customer %d: %s", id, err)
 		}
-	}(customerId)
+	})
 }
 
 // generateCustomerDepositAddress generates new addresses for customers to deposit into
 func (m *Mixer) generateCustomerDepositAddress() (crypto.Address, error) {
-	address, err := crypto.CreateAddress()
+	address, err := m.ledger.CreateAddress()
 	if err != nil {
 		return "", err
 	}
@@ -118,54 +282,323 @@ func (m *Mixer) generateCustomerDepositAddress() (crypto.Address, error) {
 	return address, nil
 }
 
-// PollDepositAddress checks the provided addresses to see if the customer deposited funds yet
-func (m *Mixer) PollDepositAddress(address crypto.Address) (crypto.Amount, error) {
-	amount, err := crypto.CheckAddress(address)
+// PollDepositAddress checks the provided address to see if the customer deposited funds yet
+// Deposits are read from the transaction history of the address rather than its balance, so each deposit is reported
+// individually with its sender and timestamp. The first known deposits are skipped, which lets the caller only see new ones
+func (m *Mixer) PollDepositAddress(address crypto.Address, known int) ([]crypto.Transaction, error) {
+	return m.PollDepositAddressContext(context.Background(), address, known)
+}
+
+// PollDepositAddressContext is PollDepositAddress, giving up once ctx is done
+func (m *Mixer) PollDepositAddressContext(ctx context.Context, address crypto.Address, known int) ([]crypto.Transaction, error) {
+	response, err := m.ledger.CheckAddressContext(ctx, address)
 	if err != nil {
-		return "0", err
+		return nil, err
 	}
-	if amount == crypto.Amount("0.00") {
-		return "0", nil
+
+	deposits := crypto.Incoming(response.Transactions, address)
+	if known >= len(deposits) {
+		return nil, nil
 	}
-	return amount, nil
+	return deposits[known:], nil
 }
 
 // HandleTransaction is the controller that handles the flow of customer funds
 // First it polls to check the customer deposit address for funds
 // Once funds are sent it uses the tumbler to tumble funds and send them back to the mixer
+// Every step moves the job through its states and is saved to the store,
+// so calling HandleTransaction again for an unfinished customer picks up where it stopped
 func (m *Mixer) HandleTransaction(id int) error {
-	var amount crypto.Amount
-	for {
-		deposit, err := m.PollDepositAddress(m.Customers[id].DepositAddress)
+	return m.HandleTransactionContext(context.Background(), id)
+}
+
+// HandleTransactionContext is HandleTransaction, but stops the work once ctx is done
+// Sends that went through are recorded as they go, so the job can be handled again later from where it stopped
+// A ledger that stays down longer than the retries of a single call does not fail the job: it stays where it was
+// and is handled again every PollInterval until the ledger answers, only errors that cannot go away fail it
+func (m *Mixer) HandleTransactionContext(ctx context.Context, id int) error {
+	customer, ok := m.customer(id)
+	if !ok {
+		return fmt.Errorf("unknown customer %d", id)
+	}
+
+	err := m.handle(ctx, id, &customer)
+	for err != nil && crypto.Retryable(err) && ctx.Err() == nil {
+		log.Printf("error handling customer %d, trying again in %s: %s", id, m.PollInterval, err)
+		select {
+		case <-ctx.Done():
+			return err
+		case <-time.After(m.PollInterval):
+		}
+		customer, _ = m.customer(id)
+		err = m.handle(ctx, id, &customer)
+	}
+	if err != nil && ctx.Err() != nil {
+		// stopped on purpose rather than failed, the job stays where it was to be handled again later
+		return err
+	}
+	if err != nil && !customer.State.Final() {
+		// record why the job stopped so operators and clients can see it
+		if failErr := m.moveTo(id, &customer, Failed, err.Error()); failErr != nil {
+			log.Printf("error recording failure of customer %d: %s", id, failErr)
+		}
+	}
+	return err
+}
+
+// handle runs the job from whatever state it is in until it is final
+func (m *Mixer) handle(ctx context.Context, id int, customer *CustomerData) error {
+	for !customer.State.Final() {
+		var err error
+		switch customer.State {
+		case AwaitingDeposit:
+			err = m.awaitDeposit(ctx, id, customer)
+		case Mixing:
+			err = m.mix(ctx, id, customer)
+		case PayingOut:
+			err = m.payOut(ctx, id, customer)
+		case Refunding:
+			err = m.refund(ctx, id, customer)
+		default:
+			err = fmt.Errorf("customer %d is in unknown state %q", id, customer.State)
+		}
 		if err != nil {
 			return err
 		}
-		if deposit != crypto.Amount("0") {
-			amount = deposit
-			break
+	}
+	return nil
+}
+
+// mix plans both tumbling steps (if not planned yet) and sends the deposit into house addresses
+// The fee is deducted up front: it goes straight to the fee address and only the rest is tumbled and paid out
+func (m *Mixer) mix(ctx context.Context, id int, customer *CustomerData) error {
+	if customer.Transfers == nil {
+		err := m.plan(ctx, id, customer)
+		if err != nil || customer.State != Mixing {
+			return err
 		}
-		time.Sleep(10 * time.Second)
+	} else {
+		log.Printf("**** Resuming customer %d after %d of %d transfers", id, customer.Sent, len(customer.Transfers))
 	}
 
-	log.Printf(" **** Received %s coins from address %s with return addresses %v", amount, m.Customers[id].DepositAddress,
-		m.Customers[id].CleanAddresses)
+	err := m.execute(ctx, id, customer, customer.Mixed)
+	if err != nil {
+		return err
+	}
 
-	tumblr := tumbler.New(amount)
-	err := tumblr.Mix(m.Customers[id].DepositAddress, m.HouseAddresses)
+	log.Printf("**** Tumbled coins from %s into the house addresses of round %d successfully", customer.DepositAddress,
+		customer.Round)
+	err = m.recordFee(id, *customer)
 	if err != nil {
 		return err
 	}
+	return m.moveTo(id, customer, PayingOut, "")
+}
 
-	log.Printf("**** Tumbled coins from %s to house addresses %s successfully", m.Customers[id].DepositAddress,
-		m.HouseAddresses)
+// plan joins the job to the open mixing round and plans both tumbling steps before moving any coins,
+// so the plan can be resumed after a restart
+// The deposit is sent to the houses of the round at random times before the round closes, and the payout is drawn
+// from the same houses at random times after it closed, every send within MaxDelay of now
+// A MaxDelay shorter than what is left of the round sends the deposit in sooner and pays out before the round closes
+// When the houses of the round cannot cover the payout it is drawn from every active house, and when even those cannot the
+// deposit is refunded before any coins move
+// Jobs are planned one at a time so each plan counts in the transfers of the ones before it
+func (m *Mixer) plan(ctx context.Context, id int, customer *CustomerData) error {
+	m.planMu.Lock()
+	defer m.planMu.Unlock()
 
-	err = tumblr.SendMixedFunds(m.Customers[id].CleanAddresses, m.HouseAddresses)
+	active, err := m.Wallet.Active()
 	if err != nil {
 		return err
 	}
+	round, err := m.Pool.Join(id, customer.Received, active)
+	if err != nil {
+		return err
+	}
+	// houses retired since the round opened only finish the sends already planned through them
+	houses := m.Wallet.Usable(round.Houses)
+	if len(houses) == 0 {
+		houses = active
+	}
 
-	log.Printf("**** Sent mixed coins back to %s successfully ****", m.Customers[id].CleanAddresses)
+	fee := customer.Quote.FeeFor(customer.Received)
+	tumblr := tumbler.New(customer.Received.Sub(fee), m.ledger)
+	tumblr.Strategy = m.Strategy
+	// no house is planned into more transactions than it has left before it is retired
+	tumblr.Uses, err = m.Wallet.Remaining()
+	if err != nil {
+		return err
+	}
+
+	mix, err := tumblr.PlanMix(customer.DepositAddress, houses)
+	if err != nil {
+		return err
+	}
+	if fee.IsPositive() {
+		mix = append(mix, tumbler.Transfer{From: customer.DepositAddress, To: m.FeeAddress, Amount: fee})
+	}
+	// the house balances have to cover this payout on top of every transfer still waiting to be sent, only coins already on
+	// the ledger or sent in by this job's own mix count, the sends of other jobs may land late or never
+	tumblr.Pending = m.pending()
+	tumblr.Before = mix
+	payout, err := tumblr.PlanPayout(ctx, customer.CleanAddresses, customer.Percentages, houses)
+	if _, ok := err.(*tumbler.InsufficientLiquidityError); ok {
+		payout, err = tumblr.PlanPayout(ctx, customer.CleanAddresses, customer.Percentages, active)
+	}
+	if shortage, ok := err.(*tumbler.InsufficientLiquidityError); ok {
+		log.Printf("**** Refunding customer %d: %s", id, shortage)
+		*customer, err = m.updateCustomer(id, func(c *CustomerData) error {
+			c.queueRefunds(c.Deposits, shortage.Error())
+			return c.transition(Refunding, shortage.Error())
+		})
+		return err
+	}
+	if err != nil {
+		return err
+	}
+	now := time.Now().UTC()
+	pooling := round.Closes.Sub(now)
+	if pooling > customer.MaxDelay {
+		pooling = customer.MaxDelay
+	}
+	if pooling < 0 {
+		pooling = 0
+	}
+	mix = tumbler.Schedule(mix, now, pooling)
+	*customer, err = m.updateCustomer(id, func(c *CustomerData) error {
+		c.Round = round.Id
+		c.FeeAmount = fee
+		c.Transfers = append(mix, tumbler.Schedule(payout, now.Add(pooling), c.MaxDelay-pooling)...)
+		c.Mixed = len(mix)
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	err = m.Wallet.Use(customer.Transfers)
+	if err != nil {
+		log.Printf("error counting the house addresses used by customer %d: %s", id, err)
+	}
+	return nil
+}
+
+// payOut sends the rest of the plan from house addresses to the customer clean addresses
+func (m *Mixer) payOut(ctx context.Context, id int, customer *CustomerData) error {
+	err := m.execute(ctx, id, customer, len(customer.Transfers))
+	if err != nil {
+		return err
+	}
+
+	log.Printf("**** Sent mixed coins back to %s successfully ****", customer.CleanAddresses)
+	return m.moveTo(id, customer, Completed, "")
+}
+
+// execute sends the planned transfers up to (not including) transfers[end], saving progress after each one
+func (m *Mixer) execute(ctx context.Context, id int, customer *CustomerData, end int) error {
+	if customer.Sent >= end {
+		return nil
+	}
+
+	tumblr := tumbler.New(customer.Received, m.ledger)
+	// every send is marked on the customer record first, so a restart can tell whether it went through
+	tumblr.Sending = customer.Sending
+	tumblr.Mark = func(sending tumbler.Sending) error {
+		updated, err := m.updateCustomer(id, func(c *CustomerData) error {
+			c.Sending = &sending
+			return nil
+		})
+		*customer = updated
+		return err
+	}
+	return tumblr.ExecuteContext(ctx, customer.Transfers[:end], customer.Sent, func(sent int) error {
+		updated, err := m.updateCustomer(id, func(c *CustomerData) error {
+			c.Sent = sent
+			c.Sending = nil
+			return nil
+		})
+		*customer = updated
+		return err
+	})
+}
 
+// moveTo transitions the customer to a new state and saves it
+func (m *Mixer) moveTo(id int, customer *CustomerData, to State, reason string) error {
+	updated, err := m.updateCustomer(id, func(c *CustomerData) error {
+		return c.transition(to, reason)
+	})
+	if err != nil {
+		return err
+	}
+	*customer = updated
+	log.Printf("**** Customer %d is now %s", id, to)
 	return nil
 }
 
+// awaitDeposit polls the deposit address until at least one deposit arrives and records the deposits on the customer
+// Deposits outside the guidelines of the tumbler are queued for a refund instead of being mixed
+// A job that receives nothing within DepositWindow is cancelled, anything sent to it afterwards is refunded as a late deposit
+func (m *Mixer) awaitDeposit(ctx context.Context, id int, customer *CustomerData) error {
+	var deposits []crypto.Transaction
+	for {
+		// the job may have been cancelled while waiting
+		current, _ := m.customer(id)
+		if current.State != AwaitingDeposit {
+			*customer = current
+			return nil
+		}
+
+		if len(customer.History) > 0 && time.Since(customer.History[0].At) > m.DepositWindow {
+			return m.moveTo(id, customer, Cancelled, fmt.Sprintf("no deposit received within %s", m.DepositWindow))
+		}
+
+		found, err := m.PollDepositAddressContext(ctx, customer.DepositAddress, len(deposits))
+		if err != nil {
+			return err
+		}
+		for _, deposit := range found {
+			log.Printf(" **** Received deposit of %s coins from %s into %s at %s", deposit.Amount, deposit.From,
+				customer.DepositAddress, deposit.Timestamp.Format(time.RFC3339))
+		}
+		deposits = append(deposits, found...)
+		if len(deposits) > 0 {
+			break
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(m.PollInterval):
+		}
+	}
+
+	received := crypto.Zero
+	for _, deposit := range deposits {
+		received = received.Add(deposit.Amount)
+	}
+	rejected := m.Limits.Check(received)
+	if rejected == nil {
+		// a fee that takes (almost) the whole deposit leaves nothing to mix
+		rejected = checkFee(customer.Quote, received, len(customer.CleanAddresses))
+	}
+
+	updated, err := m.updateCustomer(id, func(c *CustomerData) error {
+		if c.State != AwaitingDeposit {
+			// cancelled in the meantime, the deposits are refunded as strays by WatchDeposits
+			return nil
+		}
+		c.Deposits = deposits
+		c.Received = received
+		if rejected != nil {
+			c.queueRefunds(deposits, rejected.Error())
+			return c.transition(Refunding, rejected.Error())
+		}
+		return c.transition(Mixing, "")
+	})
+	if err != nil {
+		return err
+	}
+	*customer = updated
+
+	log.Printf(" **** Received %s coins from address %s with return addresses %v, customer %d is now %s",
+		customer.Received, customer.DepositAddress, customer.CleanAddresses, id, customer.State)
+	return nil
+}
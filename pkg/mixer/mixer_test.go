@@ -1,12 +1,109 @@
 package mixer
 
 import (
+	"context"
+	"encoding/json"
+	"fmt"
 	"github.com/Denton24646/gtumbler/pkg/crypto"
+	"github.com/Denton24646/gtumbler/pkg/mixer/tumbler"
+	"github.com/Denton24646/gtumbler/pkg/store"
+	"net/http"
+	"reflect"
+	"strconv"
 	"testing"
+	"time"
 )
 
+// newTestLedger returns an in-memory ledger seeded like the JobCoin UI: a funded Genesis address and five house addresses
+func newTestLedger(t *testing.T) *crypto.MemoryLedger {
+	ledger := crypto.NewMemoryLedger()
+	seeds := map[crypto.Address]string{
+		"Genesis": "100",
+		"House1":  "10",
+		"House2":  "10",
+		"House3":  "10",
+		"House4":  "10",
+		"House5":  "10",
+	}
+	for address, amount := range seeds {
+		if err := ledger.Mint(address, crypto.MustParseAmount(amount)); err != nil {
+			t.Fatalf("error seeding address %s: %s", address, err)
+		}
+	}
+	return ledger
+}
+
+// saveCustomer writes the record for id to the store and the in memory map as it is, without going through the state
+// machine, so tests can start a job from any point
+func (m *Mixer) saveCustomer(id int, customer CustomerData) error {
+	record, err := json.Marshal(customer)
+	if err != nil {
+		return err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	err = m.store.Put(customersBucket, strconv.Itoa(id), record)
+	if err != nil {
+		return err
+	}
+	m.Customers[id] = customer
+	return nil
+}
+
+// downLedger is a MemoryLedger that is down for a while: after the first calls go through,
+// the next failures calls fail with a server error as if the ledger could not be reached
+type downLedger struct {
+	*crypto.MemoryLedger
+	after    int
+	failures int
+	calls    int
+}
+
+func (d *downLedger) down() error {
+	d.calls++
+	if d.calls <= d.after || d.calls > d.after+d.failures {
+		return nil
+	}
+	return &crypto.StatusError{Status: http.StatusServiceUnavailable, Target: "/api"}
+}
+
+func (d *downLedger) Send(from crypto.Address, to crypto.Address, size crypto.Amount) error {
+	return d.SendContext(context.Background(), from, to, size)
+}
+
+func (d *downLedger) SendContext(ctx context.Context, from crypto.Address, to crypto.Address, size crypto.Amount) error {
+	if err := d.down(); err != nil {
+		return err
+	}
+	return d.MemoryLedger.SendContext(ctx, from, to, size)
+}
+
+func (d *downLedger) Balance(address crypto.Address) (crypto.Amount, error) {
+	return d.BalanceContext(context.Background(), address)
+}
+
+func (d *downLedger) BalanceContext(ctx context.Context, address crypto.Address) (crypto.Amount, error) {
+	if err := d.down(); err != nil {
+		return crypto.Zero, err
+	}
+	return d.MemoryLedger.BalanceContext(ctx, address)
+}
+
+func (d *downLedger) CheckAddress(address crypto.Address) (*crypto.CheckAddressResponse, error) {
+	return d.CheckAddressContext(context.Background(), address)
+}
+
+func (d *downLedger) CheckAddressContext(ctx context.Context, address crypto.Address) (*crypto.CheckAddressResponse, error) {
+	if err := d.down(); err != nil {
+		return nil, err
+	}
+	return d.MemoryLedger.CheckAddressContext(ctx, address)
+}
+
 func TestMixer_CreateDepositAddress(t *testing.T) {
-	testMixer := New()
+	testMixer := New(newTestLedger(t), store.NewMemory())
 	_, err := testMixer.generateCustomerDepositAddress()
 	if err != nil {
 		t.Errorf("error generating deposit address: %s", err)
@@ -15,41 +112,275 @@ func TestMixer_CreateDepositAddress(t *testing.T) {
 }
 
 func TestMixer_PollDepositAddress(t *testing.T) {
-	depositAddress := crypto.Address("Genesis")
-	testMixer := New()
+	ledger := newTestLedger(t)
+	testMixer := New(ledger, store.NewMemory())
+	depositAddress, err := testMixer.generateCustomerDepositAddress()
+	if err != nil {
+		t.Fatalf("error generating deposit address: %s", err)
+	}
+
+	deposits, err := testMixer.PollDepositAddress(depositAddress, 0)
+	if err != nil {
+		t.Fatalf("error polling deposit address %s: %s", depositAddress, err)
+	}
+	if len(deposits) != 0 {
+		t.Errorf("expected no deposits in a new address, got %d", len(deposits))
+	}
+
+	// each deposit is reported separately along with its sender
+	for _, amount := range []string{"1", "0.5"} {
+		err = ledger.Send("Genesis", depositAddress, crypto.MustParseAmount(amount))
+		if err != nil {
+			t.Fatalf("error sending funds: %s", err)
+		}
+	}
 
-	result, err := testMixer.PollDepositAddress(depositAddress)
+	deposits, err = testMixer.PollDepositAddress(depositAddress, 0)
 	if err != nil {
-		t.Errorf("error polling deposit address %s", depositAddress)
+		t.Fatalf("error polling deposit address %s: %s", depositAddress, err)
+	}
+	if len(deposits) != 2 {
+		t.Fatalf("expected 2 deposits, got %d", len(deposits))
+	}
+	if deposits[1].From != "Genesis" || deposits[1].Amount != crypto.MustParseAmount("0.5") {
+		t.Errorf("unexpected deposit %+v", deposits[1])
 	}
 
-	t.Logf("address %s contains %s funds", depositAddress, result)
+	// deposits the caller already knows about are skipped
+	deposits, err = testMixer.PollDepositAddress(depositAddress, 2)
+	if err != nil {
+		t.Fatalf("error polling deposit address %s: %s", depositAddress, err)
+	}
+	if len(deposits) != 0 {
+		t.Errorf("expected no new deposits, got %d", len(deposits))
+	}
 }
 
 func TestMixer_HandleTransaction(t *testing.T) {
+	ledger := newTestLedger(t)
+
 	// first generate a new address with some funds in it, simulating a valid deposit
-	depositAddr, err := crypto.CreateAddress()
+	depositAddr, err := ledger.CreateAddress()
 	if err != nil {
 		t.Fatalf("error creating address: %s", err)
 	}
 
 	// seed newly generated address with funds
-	err = crypto.Send(crypto.Address("Genesis"), depositAddr, crypto.Amount("1.0"))
+	err = ledger.Send(crypto.Address("Genesis"), depositAddr, crypto.MustParseAmount("1.0"))
 	if err != nil {
 		t.Fatalf(" error sending funds: %s", err)
 	}
 
-	// create mixer and send funds (after being mixed) back to genesis address
-	testMixer := New()
-	testMixer.Customers[12] = CustomerData{
+	// create mixer and send funds (after being mixed) back to a clean address
+	cleanAddr := crypto.Address("Clean")
+	testMixer := New(ledger, store.NewMemory())
+	testMixer.Pool.Interval = 0
+	err = testMixer.saveCustomer(12, CustomerData{
 		CleanAddresses: []crypto.Address{
-			0: "Genesis",
+			0: cleanAddr,
 		},
 		DepositAddress: depositAddr,
-		Fee:            0.05,
+		MaxDelay:       20 * time.Millisecond,
+		Quote:          Quote{Rate: 0.05},
+		State:          AwaitingDeposit,
+	})
+	if err != nil {
+		t.Fatalf("error saving customer: %s", err)
 	}
 	err = testMixer.HandleTransaction(12)
 	if err != nil {
 		t.Errorf("error handling transaction: %s", err)
 	}
+
+	balance, err := ledger.Balance(cleanAddr)
+	if err != nil {
+		t.Fatalf("error checking balance of address %s: %s", cleanAddr, err)
+	}
+	// the 5% fee is deducted before the payout and collected in the fee address
+	if balance != crypto.MustParseAmount("0.95") {
+		t.Errorf("expected clean address %s to hold %s, got %s", cleanAddr, "0.95", balance)
+	}
+	fees, err := ledger.Balance(testMixer.FeeAddress)
+	if err != nil {
+		t.Fatalf("error checking balance of address %s: %s", testMixer.FeeAddress, err)
+	}
+	if fees != crypto.MustParseAmount("0.05") {
+		t.Errorf("expected fee address %s to hold %s, got %s", testMixer.FeeAddress, "0.05", fees)
+	}
+
+	feeLedger, err := testMixer.FeeLedger()
+	if err != nil {
+		t.Fatalf("error reading fee ledger: %s", err)
+	}
+	if len(feeLedger.Fees) != 1 || feeLedger.Fees[0].Id != 12 || feeLedger.Total != crypto.MustParseAmount("0.05") {
+		t.Errorf("expected a single fee of 0.05 for customer 12, got %+v", feeLedger)
+	}
+
+	// the job went through every state on its way to completion
+	customer, _ := testMixer.customer(12)
+	var states []State
+	for _, transition := range customer.History {
+		states = append(states, transition.To)
+	}
+	expected := []State{Mixing, PayingOut, Completed}
+	if !reflect.DeepEqual(states, expected) {
+		t.Errorf("expected transitions %v, got %v", expected, states)
+	}
+
+	// every transfer was scheduled within the delay the customer chose, counted from when the deposit was mixed
+	mixedAt := customer.History[0].At
+	for i, transfer := range customer.Transfers {
+		if transfer.At.Before(mixedAt) || transfer.At.After(mixedAt.Add(customer.MaxDelay+time.Second)) {
+			t.Errorf("transfer %d scheduled at %s, outside of the %s delay after %s", i, transfer.At, customer.MaxDelay, mixedAt)
+		}
+	}
+}
+
+func TestMixer_HandleTransactionCancelled(t *testing.T) {
+	ledger := newTestLedger(t)
+	testMixer := New(ledger, store.NewMemory())
+	testMixer.PollInterval = 10 * time.Millisecond
+	err := testMixer.saveCustomer(13, CustomerData{
+		CleanAddresses: []crypto.Address{"Clean"},
+		DepositAddress: "Deposit",
+		State:          AwaitingDeposit,
+	})
+	if err != nil {
+		t.Fatalf("error saving customer: %s", err)
+	}
+
+	// nothing is ever deposited, the job only stops because the context does
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	err = testMixer.HandleTransactionContext(ctx, 13)
+	if err != context.DeadlineExceeded {
+		t.Errorf("expected the job to stop with %s, got %v", context.DeadlineExceeded, err)
+	}
+	customer, _ := testMixer.customer(13)
+	if customer.State != AwaitingDeposit {
+		t.Errorf("expected a stopped job to stay %s, it is %s", AwaitingDeposit, customer.State)
+	}
+}
+
+func TestMixer_HandleTransactionLedgerDown(t *testing.T) {
+	tableTests := []struct {
+		after    int
+		failures int
+	}{
+		// down while waiting for the deposit
+		{0, 10},
+		// down when the deposit is sent into the houses
+		{4, 10},
+		// down when the payout is sent
+		{6, 10},
+	}
+
+	for i, tt := range tableTests {
+		t.Run(fmt.Sprintf("test %d", i), func(t *testing.T) {
+			memory := newTestLedger(t)
+			depositAddr := crypto.Address("Deposit")
+			if err := memory.Send("Genesis", depositAddr, crypto.MustParseAmount("1")); err != nil {
+				t.Fatalf("error sending funds: %s", err)
+			}
+			ledger := &downLedger{MemoryLedger: memory, after: tt.after, failures: tt.failures}
+
+			testMixer := New(ledger, store.NewMemory())
+			testMixer.Pool.Interval = 0
+			testMixer.PollInterval = time.Millisecond
+			// a single send each way keeps the number of ledger calls before the payout fixed
+			testMixer.Strategy = tumbler.RandomStrategy{MinChunks: 1, MaxChunks: 1}
+			err := testMixer.saveCustomer(14, CustomerData{
+				CleanAddresses: []crypto.Address{"Clean"},
+				DepositAddress: depositAddr,
+				State:          AwaitingDeposit,
+			})
+			if err != nil {
+				t.Fatalf("error saving customer: %s", err)
+			}
+			if err := testMixer.HandleTransaction(14); err != nil {
+				t.Fatalf("record %d error handling transaction: %s", i, err)
+			}
+
+			// the job waited for the ledger instead of failing, and paid out every coin exactly once
+			customer, _ := testMixer.customer(14)
+			if customer.State != Completed {
+				t.Errorf("record %d expected the job to be %s, got %s", i, Completed, customer.State)
+			}
+			if ledger.calls <= tt.after+tt.failures {
+				t.Errorf("record %d expected the ledger to go down, only %d calls were made", i, ledger.calls)
+			}
+			balance, _ := memory.Balance("Clean")
+			if balance != crypto.MustParseAmount("1") {
+				t.Errorf("record %d expected the clean address to hold 1, got %s", i, balance)
+			}
+		})
+	}
+}
+
+func TestMixer_Resume(t *testing.T) {
+	ledger := newTestLedger(t)
+	s := store.NewMemory()
+	depositAddr := crypto.Address("Deposit")
+	cleanAddr := crypto.Address("Clean")
+	err := ledger.Send("Genesis", depositAddr, crypto.MustParseAmount("1"))
+	if err != nil {
+		t.Fatalf("error sending funds: %s", err)
+	}
+
+	// simulate a mixer that stopped after the first of its planned transfers
+	transfers := []tumbler.Transfer{
+		{From: depositAddr, To: "House1", Amount: crypto.MustParseAmount("0.5")},
+		{From: depositAddr, To: "House2", Amount: crypto.MustParseAmount("0.5")},
+		{From: "House3", To: cleanAddr, Amount: crypto.MustParseAmount("1")},
+	}
+	if err := ledger.Send(depositAddr, "House1", transfers[0].Amount); err != nil {
+		t.Fatalf("error sending funds: %s", err)
+	}
+	stopped := New(ledger, s)
+	err = stopped.saveCustomer(7, CustomerData{
+		CleanAddresses: []crypto.Address{cleanAddr},
+		DepositAddress: depositAddr,
+		Received:       crypto.MustParseAmount("1"),
+		Transfers:      transfers,
+		Mixed:          2,
+		Sent:           1,
+		State:          Mixing,
+	})
+	if err != nil {
+		t.Fatalf("error saving customer: %s", err)
+	}
+	err = stopped.saveCustomer(8, CustomerData{DepositAddress: "Finished", State: Completed})
+	if err != nil {
+		t.Fatalf("error saving customer: %s", err)
+	}
+
+	// a new mixer on the same store picks the unfinished customer back up
+	restarted := New(ledger, s)
+	resumed, err := restarted.Resume()
+	if err != nil {
+		t.Fatalf("error resuming: %s", err)
+	}
+	if len(resumed) != 1 || resumed[0] != 7 {
+		t.Fatalf("expected only customer 7 to be resumed, got %v", resumed)
+	}
+
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		customer, _ := restarted.customer(7)
+		if customer.State == Completed {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("customer was not finished after resuming, sent %d transfers", customer.Sent)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	// the transfer sent before the restart must not be repeated
+	for address, expected := range map[crypto.Address]string{depositAddr: "0", "House1": "10.5", cleanAddr: "1"} {
+		balance, _ := ledger.Balance(address)
+		if balance != crypto.MustParseAmount(expected) {
+			t.Errorf("expected %s to hold %s, got %s", address, expected, balance)
+		}
+	}
 }
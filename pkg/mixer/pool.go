@@ -0,0 +1,169 @@
+package mixer
+
+import (
+	"encoding/json"
+	"github.com/Denton24646/gtumbler/pkg/crypto"
+	"github.com/Denton24646/gtumbler/pkg/models"
+	"github.com/Denton24646/gtumbler/pkg/store"
+	"log"
+	"math/rand"
+	"net/http"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// roundsBucket is the store bucket holding one json encoded Round per round id
+const roundsBucket = "rounds"
+
+// defaultRoundInterval is how long a mixing round stays open for customers to join
+const defaultRoundInterval = time.Minute
+
+// defaultRoundHouses is how many house addresses the deposits of a round are pooled into
+const defaultRoundHouses = 3
+
+// Round is a batch of customers mixed together
+// Every customer that starts mixing while the round is open sends its deposit into the same few house addresses,
+// and payouts only start once the round closed and are paid from those addresses. A payout is then drawn from the
+// combined deposits of the whole round and cannot be traced back to a single deposit
+type Round struct {
+	Id      int              `json:"id"`
+	Houses  []crypto.Address `json:"houses"`
+	Opened  time.Time        `json:"opened"`
+	Closes  time.Time        `json:"closes"`
+	Members []int            `json:"members"`
+	// Volume is the total deposited by the members of the round, fees included
+	Volume crypto.Amount `json:"volume"`
+}
+
+// Pool coordinates mixing rounds, there is at most one open round at a time
+type Pool struct {
+	// Interval is how long a round stays open, with no interval every customer mixes on its own
+	Interval time.Duration
+	// Houses is how many house addresses a round pools deposits into, zero means all of them
+	Houses int
+
+	mu      sync.Mutex
+	current *Round
+	// lastId is the id of the newest round, loaded from store the first time a round is opened
+	lastId int
+	loaded bool
+	store  store.Store
+}
+
+func NewPool(store store.Store) *Pool {
+	return &Pool{
+		Interval: defaultRoundInterval,
+		Houses:   defaultRoundHouses,
+		store:    store,
+	}
+}
+
+// Join adds a customer depositing amount to the open round, opening a new round over houses when none is open
+func (p *Pool) Join(id int, amount crypto.Amount, houses []crypto.Address) (Round, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	now := time.Now().UTC()
+	if p.current == nil || !now.Before(p.current.Closes) {
+		round, err := p.open(now, houses)
+		if err != nil {
+			return Round{}, err
+		}
+		p.current = round
+	}
+
+	round := *p.current
+	round.Members = append(append([]int(nil), round.Members...), id)
+	round.Volume = round.Volume.Add(amount)
+	err := p.save(round)
+	if err != nil {
+		return Round{}, err
+	}
+	*p.current = round
+
+	log.Printf("**** Customer %d joined mixing round %d with %d customers, closing at %s", id, round.Id,
+		len(round.Members), round.Closes.Format(time.RFC3339))
+	return round, nil
+}
+
+// open starts a new round pooling into a random selection of houses
+func (p *Pool) open(now time.Time, houses []crypto.Address) (*Round, error) {
+	if !p.loaded {
+		rounds, err := p.Rounds()
+		if err != nil {
+			return nil, err
+		}
+		for _, round := range rounds {
+			if round.Id > p.lastId {
+				p.lastId = round.Id
+			}
+		}
+		p.loaded = true
+	}
+
+	count := p.Houses
+	if count <= 0 || count > len(houses) {
+		count = len(houses)
+	}
+	var selected []crypto.Address
+	for _, i := range rand.Perm(len(houses))[:count] {
+		selected = append(selected, houses[i])
+	}
+
+	p.lastId++
+	return &Round{
+		Id:     p.lastId,
+		Houses: selected,
+		Opened: now,
+		Closes: now.Add(p.Interval),
+		Volume: crypto.Zero,
+	}, nil
+}
+
+func (p *Pool) save(round Round) error {
+	record, err := json.Marshal(&round)
+	if err != nil {
+		return err
+	}
+	return p.store.Put(roundsBucket, strconv.Itoa(round.Id), record)
+}
+
+// Rounds returns every round so far, oldest first
+func (p *Pool) Rounds() ([]Round, error) {
+	records, err := p.store.List(roundsBucket)
+	if err != nil {
+		return nil, err
+	}
+
+	rounds := []Round{}
+	for _, record := range records {
+		round := Round{}
+		err := json.Unmarshal(record, &round)
+		if err != nil {
+			return nil, err
+		}
+		rounds = append(rounds, round)
+	}
+	sort.Slice(rounds, func(i, j int) bool {
+		return rounds[i].Id < rounds[j].Id
+	})
+	return rounds, nil
+}
+
+// Rounds is the GET /rounds endpoint for operators of the mixer - it lists every mixing round and who was in it
+func (m *Mixer) Rounds(w http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodGet {
+		writeError(w, http.StatusMethodNotAllowed, models.ErrMethodNotAllowed, "use GET to list the mixing rounds")
+		return
+	}
+
+	rounds, err := m.Pool.Rounds()
+	if err != nil {
+		log.Printf("error reading mixing rounds: %s", err)
+		writeError(w, http.StatusInternalServerError, models.ErrInternal, "error reading mixing rounds")
+		return
+	}
+	writeJSON(w, http.StatusOK, rounds)
+}
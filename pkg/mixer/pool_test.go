@@ -0,0 +1,177 @@
+package mixer
+
+import (
+	"context"
+	"fmt"
+	"github.com/Denton24646/gtumbler/pkg/crypto"
+	"github.com/Denton24646/gtumbler/pkg/store"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestPool_Join(t *testing.T) {
+	s := store.NewMemory()
+	houses := []crypto.Address{"House1", "House2", "House3", "House4", "House5"}
+	pool := NewPool(s)
+	pool.Interval = 50 * time.Millisecond
+	pool.Houses = 2
+
+	first, err := pool.Join(1, crypto.MustParseAmount("1"), houses)
+	if err != nil {
+		t.Fatalf("error joining round: %s", err)
+	}
+	second, err := pool.Join(2, crypto.MustParseAmount("2"), houses)
+	if err != nil {
+		t.Fatalf("error joining round: %s", err)
+	}
+	if first.Id != 1 || second.Id != 1 || !reflect.DeepEqual(first.Houses, second.Houses) || len(second.Houses) != 2 {
+		t.Fatalf("expected both customers in round 1 sharing two houses, got %+v and %+v", first, second)
+	}
+	if !reflect.DeepEqual(second.Members, []int{1, 2}) || second.Volume != crypto.MustParseAmount("3") {
+		t.Errorf("expected customers 1 and 2 with a volume of 3, got %+v", second)
+	}
+
+	// once the round closed the next customer opens a new one
+	time.Sleep(pool.Interval)
+	third, err := pool.Join(3, crypto.MustParseAmount("1"), houses)
+	if err != nil {
+		t.Fatalf("error joining round: %s", err)
+	}
+	if third.Id != 2 || !reflect.DeepEqual(third.Members, []int{3}) {
+		t.Errorf("expected customer 3 alone in round 2, got %+v", third)
+	}
+
+	// round ids carry on after a restart
+	restarted := NewPool(s)
+	fourth, err := restarted.Join(4, crypto.MustParseAmount("1"), houses)
+	if err != nil {
+		t.Fatalf("error joining round: %s", err)
+	}
+	if fourth.Id != 3 {
+		t.Errorf("expected a restarted pool to open round 3, got %d", fourth.Id)
+	}
+	rounds, err := restarted.Rounds()
+	if err != nil {
+		t.Fatalf("error listing rounds: %s", err)
+	}
+	if len(rounds) != 3 || rounds[0].Id != 1 || rounds[2].Id != 3 {
+		t.Errorf("expected rounds 1 to 3, got %+v", rounds)
+	}
+}
+
+func TestMixer_HandleTransactionPooled(t *testing.T) {
+	ledger := newTestLedger(t)
+	testMixer := New(ledger, store.NewMemory())
+	testMixer.Pool.Interval = 200 * time.Millisecond
+
+	ids := []int{21, 22}
+	for _, id := range ids {
+		depositAddr, err := ledger.CreateAddress()
+		if err != nil {
+			t.Fatalf("error creating address: %s", err)
+		}
+		if err := ledger.Send("Genesis", depositAddr, crypto.MustParseAmount("1")); err != nil {
+			t.Fatalf("error sending funds: %s", err)
+		}
+		err = testMixer.saveCustomer(id, CustomerData{
+			CleanAddresses: []crypto.Address{crypto.Address("Clean" + depositAddr)},
+			DepositAddress: depositAddr,
+			MaxDelay:       time.Second,
+			State:          AwaitingDeposit,
+		})
+		if err != nil {
+			t.Fatalf("error saving customer: %s", err)
+		}
+	}
+
+	var wg sync.WaitGroup
+	for _, id := range ids {
+		wg.Add(1)
+		go func(id int) {
+			defer wg.Done()
+			if err := testMixer.HandleTransaction(id); err != nil {
+				t.Errorf("error handling customer %d: %s", id, err)
+			}
+		}(id)
+	}
+	wg.Wait()
+
+	rounds, err := testMixer.Pool.Rounds()
+	if err != nil {
+		t.Fatalf("error listing rounds: %s", err)
+	}
+	if len(rounds) != 1 || len(rounds[0].Members) != 2 {
+		t.Fatalf("expected both customers in a single round, got %+v", rounds)
+	}
+	round := rounds[0]
+
+	pooled := make(map[crypto.Address]bool)
+	for _, house := range round.Houses {
+		pooled[house] = true
+	}
+	for _, id := range ids {
+		customer, _ := testMixer.customer(id)
+		if customer.State != Completed || customer.Round != round.Id {
+			t.Errorf("expected customer %d completed in round %d, got %s in round %d", id, round.Id, customer.State, customer.Round)
+		}
+		for i, transfer := range customer.Transfers {
+			if i < customer.Mixed {
+				// deposits land in the pool before the round closes
+				if transfer.To != testMixer.FeeAddress && (!pooled[transfer.To] || transfer.At.After(round.Closes)) {
+					t.Errorf("customer %d mixed into %s at %s, outside of round %+v", id, transfer.To, transfer.At, round)
+				}
+				continue
+			}
+			// and payouts are only drawn from the pool once it closed
+			if !pooled[transfer.From] || transfer.At.Before(round.Closes) {
+				t.Errorf("customer %d paid out from %s at %s, outside of round %+v", id, transfer.From, transfer.At, round)
+			}
+		}
+	}
+}
+
+func TestMixer_PlanWithinMaxDelay(t *testing.T) {
+	tableTests := []struct {
+		round    time.Duration
+		maxDelay time.Duration
+	}{
+		{time.Hour, time.Minute},
+		{time.Minute, time.Hour},
+		{0, time.Minute},
+		{time.Minute, 0},
+	}
+
+	for i, tt := range tableTests {
+		t.Run(fmt.Sprintf("test %d", i), func(t *testing.T) {
+			testMixer := New(newTestLedger(t), store.NewMemory())
+			testMixer.Pool.Interval = tt.round
+			customer := CustomerData{
+				CleanAddresses: []crypto.Address{"Clean"},
+				DepositAddress: "Deposit",
+				MaxDelay:       tt.maxDelay,
+				Received:       crypto.MustParseAmount("1"),
+				State:          Mixing,
+			}
+			if err := testMixer.saveCustomer(8, customer); err != nil {
+				t.Fatalf("error saving customer: %s", err)
+			}
+
+			start := time.Now().UTC()
+			if err := testMixer.plan(context.Background(), 8, &customer); err != nil {
+				t.Fatalf("record %d error planning: %s", i, err)
+			}
+			// the last payout is due within the delay the customer chose, however long the round is
+			for j, transfer := range customer.Transfers {
+				if transfer.At.Before(start) || transfer.At.After(start.Add(tt.maxDelay+time.Second)) {
+					t.Errorf("record %d transfer %d scheduled at %s, outside of the %s delay after %s", i, j, transfer.At,
+						tt.maxDelay, start)
+				}
+				if j >= customer.Mixed && transfer.At.Before(customer.Transfers[customer.Mixed-1].At) {
+					t.Errorf("record %d payout %d scheduled at %s, before the deposit was mixed", i, j, transfer.At)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,190 @@
+package mixer
+
+import (
+	"context"
+	"github.com/Denton24646/gtumbler/pkg/crypto"
+	"log"
+	"time"
+)
+
+// Refund is a deposit that is sent back to the address it came from
+type Refund struct {
+	Deposit crypto.Transaction
+	Reason  string
+	// Sent is set once the coins went back to the sender, At records when
+	Sent bool
+	At   time.Time
+	// Error is set when the refund cannot be sent at all, for example because the deposit has no known sender
+	Error string
+	// Seen is how many transactions the deposit address took part in right before the refund was sent, it is set before
+	// sending so a refund that went through without being marked Sent is not sent again (see crypto.Landed)
+	Seen *int
+}
+
+// queueRefunds records that deposits have to be sent back to their senders for reason
+func (c *CustomerData) queueRefunds(deposits []crypto.Transaction, reason string) {
+	for _, deposit := range deposits {
+		c.Refunds = append(c.Refunds, Refund{
+			Deposit: deposit,
+			Reason:  reason,
+		})
+	}
+}
+
+// refund sends back every queued refund of a rejected deposit and marks the job refunded
+func (m *Mixer) refund(ctx context.Context, id int, customer *CustomerData) error {
+	err := m.sendRefunds(ctx, id)
+	if err != nil {
+		return err
+	}
+	*customer, _ = m.customer(id)
+
+	log.Printf("**** Refunded the deposit of customer %d", id)
+	return m.moveTo(id, customer, Refunded, "")
+}
+
+// sendRefunds sends every refund of the customer that was not sent yet, recording each one as it goes
+// Refunds are sent one customer at a time so the job and the deposit watcher never send the same refund twice
+func (m *Mixer) sendRefunds(ctx context.Context, id int) error {
+	m.refundMu.Lock()
+	defer m.refundMu.Unlock()
+
+	customer, _ := m.customer(id)
+	for i, refund := range customer.Refunds {
+		if refund.Sent || refund.Error != "" {
+			continue
+		}
+
+		update := func(c *CustomerData) error {
+			c.Refunds[i].Sent = true
+			c.Refunds[i].At = time.Now().UTC()
+			return nil
+		}
+		if refund.Deposit.From == "" {
+			log.Printf("cannot refund deposit of %s to customer %d, the sender is unknown", refund.Deposit.Amount, id)
+			update = func(c *CustomerData) error {
+				c.Refunds[i].Error = "the sender of the deposit is unknown"
+				return nil
+			}
+		} else {
+			err := m.sendRefund(ctx, id, i, customer.DepositAddress, refund)
+			if err != nil {
+				return err
+			}
+			log.Printf("**** Refunded %s coins to %s: %s", refund.Deposit.Amount, refund.Deposit.From, refund.Reason)
+		}
+
+		_, err := m.updateCustomer(id, update)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// sendRefund sends refund i of the customer back from the deposit address, marking it with Seen first
+// A refund marked by an earlier run that already shows up on the ledger is not sent a second time
+func (m *Mixer) sendRefund(ctx context.Context, id int, i int, from crypto.Address, refund Refund) error {
+	if refund.Seen != nil {
+		landed, err := crypto.Landed(ctx, m.ledger, from, refund.Deposit.From, refund.Deposit.Amount, *refund.Seen)
+		if err != nil || landed {
+			return err
+		}
+	}
+
+	seen, err := crypto.Seen(ctx, m.ledger, from)
+	if err != nil {
+		return err
+	}
+	_, err = m.updateCustomer(id, func(c *CustomerData) error {
+		c.Refunds[i].Seen = &seen
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	return m.ledger.SendContext(ctx, from, refund.Deposit.From, refund.Deposit.Amount)
+}
+
+// WatchDeposits keeps watching the deposit address of every job that stopped accepting deposits
+// Anything sent to them late, or to a cancelled or failed job, is refunded to its sender. Refunds that failed earlier are retried
+// It checks all jobs every interval until ctx is done, a finished job only for WatchWindow after it finished (or for as long
+// as one of its refunds is not sent), so the ledger calls do not grow with every job the mixer ever ran
+func (m *Mixer) WatchDeposits(ctx context.Context, interval time.Duration) {
+	for {
+		m.checkStrayDeposits(ctx)
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(interval):
+		}
+	}
+}
+
+// checkStrayDeposits runs a single pass of WatchDeposits
+func (m *Mixer) checkStrayDeposits(ctx context.Context) {
+	m.mu.Lock()
+	var ids []int
+	for id, customer := range m.Customers {
+		// jobs awaiting their deposit or refunding it handle their own deposits
+		if customer.State == AwaitingDeposit || customer.State == Refunding || !m.watched(customer) {
+			continue
+		}
+		ids = append(ids, id)
+	}
+	m.mu.Unlock()
+
+	for _, id := range ids {
+		err := m.refundStrayDeposits(ctx, id)
+		if err != nil {
+			log.Printf("error refunding stray deposits of customer %d: %s", id, err)
+		}
+	}
+}
+
+// watched reports whether the deposit address of the job is still watched for stray deposits
+func (m *Mixer) watched(customer CustomerData) bool {
+	if !customer.State.Final() || len(customer.History) == 0 {
+		return true
+	}
+	for _, refund := range customer.Refunds {
+		if !refund.Sent {
+			return true
+		}
+	}
+	return time.Since(customer.History[len(customer.History)-1].At) <= m.WatchWindow
+}
+
+// refundStrayDeposits refunds deposits that arrived after the job stopped accepting them
+func (m *Mixer) refundStrayDeposits(ctx context.Context, id int) error {
+	customer, _ := m.customer(id)
+	found, err := m.PollDepositAddressContext(ctx, customer.DepositAddress, len(customer.Deposits))
+	if err != nil {
+		return err
+	}
+
+	if len(found) > 0 {
+		_, err = m.updateCustomer(id, func(c *CustomerData) error {
+			if len(c.Deposits) != len(customer.Deposits) {
+				// the record changed while polling, the next pass picks the deposits up
+				found = nil
+				return nil
+			}
+			reason := "late deposit, the job already received its deposit"
+			switch c.State {
+			case Cancelled:
+				reason = "deposit to a cancelled job"
+			case Failed:
+				reason = "deposit to a failed job"
+			}
+			c.Deposits = append(c.Deposits, found...)
+			c.queueRefunds(found, reason)
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	return m.sendRefunds(ctx, id)
+}
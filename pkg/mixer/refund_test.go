@@ -0,0 +1,307 @@
+package mixer
+
+import (
+	"context"
+	"fmt"
+	"github.com/Denton24646/gtumbler/pkg/crypto"
+	"github.com/Denton24646/gtumbler/pkg/mixer/tumbler"
+	"github.com/Denton24646/gtumbler/pkg/store"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestMixer_RefundOutOfPolicy(t *testing.T) {
+	tableTests := []struct {
+		deposit string
+		quote   Quote
+	}{
+		{"50", Quote{}},
+		{"0.05", Quote{}},
+		// within the limits, but the fee takes all of it
+		{"0.2", Quote{Minimum: crypto.MustParseAmount("0.5")}},
+	}
+
+	for i, tt := range tableTests {
+		t.Run(fmt.Sprintf("test %d", i), func(t *testing.T) {
+			ledger := newTestLedger(t)
+			depositAddr := crypto.Address("Deposit")
+			err := ledger.Send("Genesis", depositAddr, crypto.MustParseAmount(tt.deposit))
+			if err != nil {
+				t.Fatalf("error sending funds: %s", err)
+			}
+
+			testMixer := New(ledger, store.NewMemory())
+			err = testMixer.saveCustomer(3, CustomerData{
+				CleanAddresses: []crypto.Address{"Clean"},
+				DepositAddress: depositAddr,
+				Quote:          tt.quote,
+				State:          AwaitingDeposit,
+			})
+			if err != nil {
+				t.Fatalf("error saving customer: %s", err)
+			}
+
+			err = testMixer.HandleTransaction(3)
+			if err != nil {
+				t.Fatalf("error handling transaction: %s", err)
+			}
+
+			customer, _ := testMixer.customer(3)
+			if customer.State != Refunded {
+				t.Errorf("record %d expected customer to be %s, got %s", i, Refunded, customer.State)
+			}
+			if len(customer.Refunds) != 1 || !customer.Refunds[0].Sent || customer.Refunds[0].Deposit.From != "Genesis" {
+				t.Errorf("record %d expected a sent refund to Genesis, got %+v", i, customer.Refunds)
+			}
+
+			// the coins went back to the sender and nothing reached the clean address
+			expected := map[crypto.Address]string{"Genesis": "100", depositAddr: "0", "Clean": "0"}
+			for address, amount := range expected {
+				balance, _ := ledger.Balance(address)
+				if balance != crypto.MustParseAmount(amount) {
+					t.Errorf("record %d expected %s to hold %s, got %s", i, address, amount, balance)
+				}
+			}
+		})
+	}
+}
+
+func TestMixer_RefundCancelled(t *testing.T) {
+	ledger := newTestLedger(t)
+	testMixer := New(ledger, store.NewMemory())
+	depositAddr := crypto.Address("Deposit")
+	customer := CustomerData{CleanAddresses: []crypto.Address{"Clean"}, DepositAddress: depositAddr}
+	if err := customer.transition(AwaitingDeposit, ""); err != nil {
+		t.Fatalf("error moving to %s: %s", AwaitingDeposit, err)
+	}
+	if err := testMixer.saveCustomer(4, customer); err != nil {
+		t.Fatalf("error saving customer: %s", err)
+	}
+
+	recorder := httptest.NewRecorder()
+	testMixer.Cancel(recorder, httptest.NewRequest(http.MethodPost, "/cancel/4", nil))
+	if recorder.Code != http.StatusOK {
+		t.Fatalf("expected status 200 cancelling, got %d: %s", recorder.Code, recorder.Body)
+	}
+
+	// cancelling twice is refused
+	recorder = httptest.NewRecorder()
+	testMixer.Cancel(recorder, httptest.NewRequest(http.MethodPost, "/cancel/4", nil))
+	if recorder.Code != http.StatusConflict {
+		t.Errorf("expected status 409 cancelling a cancelled customer, got %d", recorder.Code)
+	}
+
+	// the customer deposits anyway, the watcher sends it back
+	err := ledger.Send("Genesis", depositAddr, crypto.MustParseAmount("2"))
+	if err != nil {
+		t.Fatalf("error sending funds: %s", err)
+	}
+	testMixer.checkStrayDeposits(context.Background())
+
+	customer, _ = testMixer.customer(4)
+	if len(customer.Refunds) != 1 || !customer.Refunds[0].Sent || customer.Refunds[0].Reason != "deposit to a cancelled job" {
+		t.Errorf("expected a sent refund of the deposit to a cancelled job, got %+v", customer.Refunds)
+	}
+	balance, _ := ledger.Balance("Genesis")
+	if balance != crypto.MustParseAmount("100") {
+		t.Errorf("expected Genesis to get its deposit back, holds %s", balance)
+	}
+
+	// further passes do not refund the same deposit again
+	testMixer.checkStrayDeposits(context.Background())
+	customer, _ = testMixer.customer(4)
+	if len(customer.Refunds) != 1 {
+		t.Errorf("expected exactly one refund, got %d", len(customer.Refunds))
+	}
+}
+
+func TestMixer_RefundLate(t *testing.T) {
+	ledger := newTestLedger(t)
+	depositAddr := crypto.Address("Deposit")
+	err := ledger.Send("Genesis", depositAddr, crypto.MustParseAmount("1"))
+	if err != nil {
+		t.Fatalf("error sending funds: %s", err)
+	}
+
+	testMixer := New(ledger, store.NewMemory())
+	testMixer.Pool.Interval = 0
+	err = testMixer.saveCustomer(5, CustomerData{
+		CleanAddresses: []crypto.Address{"Clean"},
+		DepositAddress: depositAddr,
+		State:          AwaitingDeposit,
+	})
+	if err != nil {
+		t.Fatalf("error saving customer: %s", err)
+	}
+	if err := testMixer.HandleTransaction(5); err != nil {
+		t.Fatalf("error handling transaction: %s", err)
+	}
+
+	// a second deposit after the job completed is refunded
+	err = ledger.Send("Genesis", depositAddr, crypto.MustParseAmount("0.5"))
+	if err != nil {
+		t.Fatalf("error sending funds: %s", err)
+	}
+	testMixer.checkStrayDeposits(context.Background())
+
+	customer, _ := testMixer.customer(5)
+	if customer.State != Completed {
+		t.Errorf("expected the job to stay %s, got %s", Completed, customer.State)
+	}
+	if len(customer.Refunds) != 1 || customer.Refunds[0].Deposit.Amount != crypto.MustParseAmount("0.5") {
+		t.Errorf("expected the late deposit to be refunded, got %+v", customer.Refunds)
+	}
+	if response := status(5, customer); response.Refunded != crypto.MustParseAmount("0.5") {
+		t.Errorf("expected status to report 0.5 refunded, got %s", response.Refunded)
+	}
+}
+
+func TestMixer_RefundWatchWindow(t *testing.T) {
+	ledger := newTestLedger(t)
+	testMixer := New(ledger, store.NewMemory())
+	testMixer.WatchWindow = time.Hour
+	// customer 6 failed before any deposit arrived, customer 7 was cancelled longer than the watch window ago
+	for id, finished := range map[int]time.Duration{6: 0, 7: 2 * time.Hour} {
+		customer := CustomerData{CleanAddresses: []crypto.Address{"Clean"}, DepositAddress: crypto.Address(fmt.Sprint("Deposit", id))}
+		final := Failed
+		if id == 7 {
+			final = Cancelled
+		}
+		for _, state := range []State{AwaitingDeposit, final} {
+			if err := customer.transition(state, ""); err != nil {
+				t.Fatalf("error moving to %s: %s", state, err)
+			}
+		}
+		customer.History[1].At = customer.History[1].At.Add(-finished)
+		if err := testMixer.saveCustomer(id, customer); err != nil {
+			t.Fatalf("error saving customer: %s", err)
+		}
+		if err := ledger.Send("Genesis", customer.DepositAddress, crypto.MustParseAmount("1")); err != nil {
+			t.Fatalf("error sending funds: %s", err)
+		}
+	}
+	testMixer.checkStrayDeposits(context.Background())
+
+	failed, _ := testMixer.customer(6)
+	if len(failed.Refunds) != 1 || !failed.Refunds[0].Sent || failed.Refunds[0].Reason != "deposit to a failed job" {
+		t.Errorf("expected a sent refund of the deposit to a failed job, got %+v", failed.Refunds)
+	}
+	// the deposit address of the old job is no longer watched
+	cancelled, _ := testMixer.customer(7)
+	if len(cancelled.Refunds) != 0 {
+		t.Errorf("expected no refund past the watch window, got %+v", cancelled.Refunds)
+	}
+	if balance, _ := ledger.Balance("Deposit7"); balance != crypto.MustParseAmount("1") {
+		t.Errorf("expected Deposit7 to keep its coins, holds %s", balance)
+	}
+}
+
+func TestMixer_RefundShortLiquidity(t *testing.T) {
+	ledger := newTestLedger(t)
+	depositAddr := crypto.Address("Deposit")
+	err := ledger.Send("Genesis", depositAddr, crypto.MustParseAmount("1"))
+	if err != nil {
+		t.Fatalf("error sending funds: %s", err)
+	}
+
+	testMixer := New(ledger, store.NewMemory())
+	testMixer.Pool.Interval = 0
+	// another job has already promised more than the houses hold
+	var promised []tumbler.Transfer
+	for _, house := range testMixer.Wallet.Seeds {
+		promised = append(promised, tumbler.Transfer{From: house, To: "Other", Amount: crypto.MustParseAmount("12")})
+	}
+	err = testMixer.saveCustomer(4, CustomerData{
+		CleanAddresses: []crypto.Address{"Other"},
+		DepositAddress: "OtherDeposit",
+		Transfers:      promised,
+		State:          PayingOut,
+	})
+	if err != nil {
+		t.Fatalf("error saving customer: %s", err)
+	}
+	err = testMixer.saveCustomer(5, CustomerData{
+		CleanAddresses: []crypto.Address{"Clean"},
+		DepositAddress: depositAddr,
+		State:          AwaitingDeposit,
+	})
+	if err != nil {
+		t.Fatalf("error saving customer: %s", err)
+	}
+	if err := testMixer.HandleTransaction(5); err != nil {
+		t.Fatalf("error handling transaction: %s", err)
+	}
+
+	// the deposit goes back untouched instead of failing halfway through the payout
+	customer, _ := testMixer.customer(5)
+	if customer.State != Refunded {
+		t.Errorf("expected the job to be %s, got %s", Refunded, customer.State)
+	}
+	if customer.Transfers != nil {
+		t.Errorf("expected nothing to be planned, got %v", customer.Transfers)
+	}
+	for address, expected := range map[crypto.Address]string{depositAddr: "0", "Genesis": "100", "Clean": "0"} {
+		balance, _ := ledger.Balance(address)
+		if balance != crypto.MustParseAmount(expected) {
+			t.Errorf("expected %s to hold %s, got %s", address, expected, balance)
+		}
+	}
+}
+
+func TestMixer_RefundResumed(t *testing.T) {
+	ledger := newTestLedger(t)
+	depositAddr := crypto.Address("Deposit")
+	// the deposit address still holds coins of its own, so a refund sent twice would go through
+	if err := ledger.Mint(depositAddr, crypto.MustParseAmount("50")); err != nil {
+		t.Fatalf("error seeding address: %s", err)
+	}
+	if err := ledger.Send("Genesis", depositAddr, crypto.MustParseAmount("20")); err != nil {
+		t.Fatalf("error sending funds: %s", err)
+	}
+	history, err := ledger.CheckAddress(depositAddr)
+	if err != nil {
+		t.Fatalf("error reading history: %s", err)
+	}
+	incoming := crypto.Incoming(history.Transactions, depositAddr)
+	deposit := incoming[len(incoming)-1]
+
+	// the refund went out right after it was marked, but the mixer stopped before recording it
+	seen, err := crypto.Seen(context.Background(), ledger, depositAddr)
+	if err != nil {
+		t.Fatalf("error reading history: %s", err)
+	}
+	if err := ledger.Send(depositAddr, "Genesis", deposit.Amount); err != nil {
+		t.Fatalf("error sending funds: %s", err)
+	}
+	customer := CustomerData{
+		CleanAddresses: []crypto.Address{"Clean"},
+		DepositAddress: depositAddr,
+		Deposits:       []crypto.Transaction{deposit},
+		Refunds:        []Refund{{Deposit: deposit, Reason: "too large", Seen: &seen}},
+	}
+	for _, state := range []State{AwaitingDeposit, Refunding} {
+		if err := customer.transition(state, ""); err != nil {
+			t.Fatalf("error moving to %s: %s", state, err)
+		}
+	}
+	testMixer := New(ledger, store.NewMemory())
+	if err := testMixer.saveCustomer(6, customer); err != nil {
+		t.Fatalf("error saving customer: %s", err)
+	}
+
+	if err := testMixer.HandleTransaction(6); err != nil {
+		t.Fatalf("error handling transaction: %s", err)
+	}
+	customer, _ = testMixer.customer(6)
+	if customer.State != Refunded || !customer.Refunds[0].Sent {
+		t.Errorf("expected the refund to be recorded as sent, got %s with %+v", customer.State, customer.Refunds)
+	}
+	for address, expected := range map[crypto.Address]string{depositAddr: "50", "Genesis": "100"} {
+		balance, _ := ledger.Balance(address)
+		if balance != crypto.MustParseAmount(expected) {
+			t.Errorf("expected %s to hold %s, got %s", address, expected, balance)
+		}
+	}
+}
@@ -0,0 +1,33 @@
+package mixer
+
+import (
+	"encoding/json"
+	"github.com/Denton24646/gtumbler/pkg/models"
+	"log"
+	"net/http"
+)
+
+// writeJSON writes body as a json response with the given http status
+func writeJSON(w http.ResponseWriter, status int, body interface{}) {
+	res, err := json.Marshal(body)
+	if err != nil {
+		log.Printf("error encoding response: %s", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_, err = w.Write(res)
+	if err != nil {
+		log.Printf("error writing response: %s", err)
+	}
+}
+
+// writeError writes the json error envelope with a machine readable code and a human readable message
+func writeError(w http.ResponseWriter, status int, code string, message string) {
+	writeJSON(w, status, &models.ErrorResponse{
+		Code:    code,
+		Message: message,
+	})
+}
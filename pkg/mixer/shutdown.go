@@ -0,0 +1,79 @@
+package mixer
+
+import (
+	"context"
+	"log"
+	"sort"
+	"time"
+)
+
+// defaultShutdownTimeout is how long Shutdown waits for running jobs to stop by default
+const defaultShutdownTimeout = 30 * time.Second
+
+// Go runs fn in the background with a context that is done once the mixer shuts down, Shutdown waits for fn to return
+// Jobs and the watchers are started with Go, once Shutdown started fn is not run at all
+func (m *Mixer) Go(fn func(ctx context.Context)) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.draining {
+		return
+	}
+
+	m.work.Add(1)
+	go func() {
+		defer m.work.Done()
+		fn(m.workCtx)
+	}()
+}
+
+// stopping reports whether Shutdown started
+func (m *Mixer) stopping() bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.draining
+}
+
+// Shutdown stops the mixer: /create turns new jobs away, every job and watcher started with Go is told to stop and
+// Shutdown waits for them to return until ctx is done
+// Jobs stop between sends and keep their state, everything sent is already saved and a send cut off halfway is marked
+// on the job, so it is checked against the ledger instead of sent again when the job resumes
+// It returns the ids of the unfinished jobs, Resume picks them up on the next start
+func (m *Mixer) Shutdown(ctx context.Context) ([]int, error) {
+	m.mu.Lock()
+	m.draining = true
+	m.mu.Unlock()
+	m.stop()
+
+	done := make(chan struct{})
+	go func() {
+		m.work.Wait()
+		close(done)
+	}()
+	var err error
+	select {
+	case <-done:
+	case <-ctx.Done():
+		err = ctx.Err()
+	}
+
+	return m.unfinished(), err
+}
+
+// unfinished logs where every job that is not final stopped and returns their ids in order
+func (m *Mixer) unfinished() []int {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	ids := []int{}
+	for id, customer := range m.Customers {
+		if !customer.State.Final() {
+			ids = append(ids, id)
+		}
+	}
+	sort.Ints(ids)
+	for _, id := range ids {
+		customer := m.Customers[id]
+		log.Printf("customer %d stopped while %s, %d of %d transfers sent", id, customer.State, customer.Sent, len(customer.Transfers))
+	}
+	return ids
+}
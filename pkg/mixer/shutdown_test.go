@@ -0,0 +1,105 @@
+package mixer
+
+import (
+	"bytes"
+	"context"
+	"github.com/Denton24646/gtumbler/pkg/crypto"
+	"github.com/Denton24646/gtumbler/pkg/mixer/tumbler"
+	"github.com/Denton24646/gtumbler/pkg/models"
+	"github.com/Denton24646/gtumbler/pkg/store"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestMixer_Shutdown(t *testing.T) {
+	ledger := newTestLedger(t)
+	s := store.NewMemory()
+	depositAddr := crypto.Address("Deposit")
+	if err := ledger.Send("Genesis", depositAddr, crypto.MustParseAmount("1")); err != nil {
+		t.Fatalf("error sending funds: %s", err)
+	}
+
+	// one job waits for its deposit, the other has a send due in an hour
+	stopped := New(ledger, s)
+	err := stopped.saveCustomer(3, CustomerData{
+		CleanAddresses: []crypto.Address{"Clean"},
+		DepositAddress: "Waiting",
+		State:          AwaitingDeposit,
+	})
+	if err != nil {
+		t.Fatalf("error saving customer: %s", err)
+	}
+	err = stopped.saveCustomer(4, CustomerData{
+		CleanAddresses: []crypto.Address{"Clean"},
+		DepositAddress: depositAddr,
+		Received:       crypto.MustParseAmount("1"),
+		Transfers: []tumbler.Transfer{
+			{From: depositAddr, To: "House1", Amount: crypto.MustParseAmount("0.5")},
+			{From: depositAddr, To: "House2", Amount: crypto.MustParseAmount("0.5"), At: time.Now().Add(time.Hour)},
+			{From: "House3", To: "Clean", Amount: crypto.MustParseAmount("1")},
+		},
+		Mixed: 2,
+		State: Mixing,
+	})
+	if err != nil {
+		t.Fatalf("error saving customer: %s", err)
+	}
+
+	testMixer := New(ledger, s)
+	testMixer.PollInterval = 10 * time.Millisecond
+	if _, err := testMixer.Resume(); err != nil {
+		t.Fatalf("error resuming: %s", err)
+	}
+	// let the second job send its first transfer
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		customer, _ := testMixer.customer(4)
+		if customer.Sent == 1 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("customer 4 did not send its first transfer")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	unfinished, err := testMixer.Shutdown(ctx)
+	if err != nil {
+		t.Fatalf("error shutting down: %s", err)
+	}
+	if !reflect.DeepEqual(unfinished, []int{3, 4}) {
+		t.Errorf("expected customers 3 and 4 to be unfinished, got %v", unfinished)
+	}
+
+	// the jobs stopped where they were instead of failing, with what was sent saved for the next start
+	for id, state := range map[int]State{3: AwaitingDeposit, 4: Mixing} {
+		customer, _ := testMixer.customer(id)
+		if customer.State != state {
+			t.Errorf("expected customer %d to stay %s, it is %s", id, state, customer.State)
+		}
+	}
+	resumed := New(ledger, s)
+	if _, err := resumed.Resume(); err != nil {
+		t.Fatalf("error resuming: %s", err)
+	}
+	if customer, _ := resumed.customer(4); customer.Sent != 1 {
+		t.Errorf("expected the stored record to have 1 transfer sent, got %d", customer.Sent)
+	}
+	if _, err := resumed.Shutdown(ctx); err != nil {
+		t.Errorf("error shutting down: %s", err)
+	}
+
+	// new jobs are turned away
+	recorder := httptest.NewRecorder()
+	body := `{"id": 5, "addresses": ["Clean1"]}`
+	testMixer.Create(recorder, httptest.NewRequest(http.MethodPost, "/create", bytes.NewBufferString(body)))
+	if recorder.Code != http.StatusServiceUnavailable || !strings.Contains(recorder.Body.String(), models.ErrShuttingDown) {
+		t.Errorf("expected /create to be refused while shutting down, got %d: %s", recorder.Code, recorder.Body)
+	}
+}
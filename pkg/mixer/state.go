@@ -0,0 +1,82 @@
+package mixer
+
+import (
+	"fmt"
+	"time"
+)
+
+// State is where a customer job is in the mixing process
+// A job moves forward through the states below, every move is recorded as a Transition on the customer record
+//
+//	AwaitingDeposit -> Mixing -> PayingOut -> Completed
+//	AwaitingDeposit -> Refunding -> Refunded (the deposit was outside the mixer guidelines)
+//	Mixing -> Refunding -> Refunded (the house addresses cannot cover the payout, no coins were moved yet)
+//	AwaitingDeposit -> Cancelled (by the customer, or because no deposit arrived in time)
+//
+// and from any state that is not final a job can end up Failed
+type State string
+
+const (
+	// AwaitingDeposit is the state of a new job: the deposit address was handed out and the mixer watches it for coins
+	AwaitingDeposit State = "awaiting_deposit"
+	// Mixing means the deposit arrived and is being moved from the deposit address into house addresses
+	Mixing State = "mixing"
+	// PayingOut means the coins are being sent from house addresses to the customer clean addresses
+	PayingOut State = "paying_out"
+	// Completed means every planned transfer was sent
+	Completed State = "completed"
+	// Refunding means the deposit was rejected and is being sent back to the address it came from
+	Refunding State = "refunding"
+	// Refunded means the deposit was sent back to the address it came from
+	Refunded State = "refunded"
+	// Cancelled means the job stopped before a deposit arrived, deposits sent to it later are refunded
+	Cancelled State = "cancelled"
+	// Failed means the job stopped because of an error, the reason is kept in the last transition
+	Failed State = "failed"
+)
+
+// transitions lists the states each state is allowed to move to
+var transitions = map[State][]State{
+	"":              {AwaitingDeposit},
+	AwaitingDeposit: {Mixing, Refunding, Cancelled, Failed},
+	Mixing:          {PayingOut, Refunding, Failed},
+	PayingOut:       {Completed, Failed},
+	Refunding:       {Refunded, Failed},
+}
+
+// Transition is a single recorded move of a job from one state to another
+type Transition struct {
+	From   State     `json:"from"`
+	To     State     `json:"to"`
+	At     time.Time `json:"at"`
+	Reason string    `json:"reason,omitempty"`
+}
+
+// Final reports whether a job in this state is finished and will not be resumed
+func (s State) Final() bool {
+	return len(transitions[s]) == 0
+}
+
+// transition moves the customer to state "to", recording when and why
+// It fails without changing the customer when the move is not allowed from the current state
+func (c *CustomerData) transition(to State, reason string) error {
+	allowed := false
+	for _, next := range transitions[c.State] {
+		if next == to {
+			allowed = true
+			break
+		}
+	}
+	if !allowed {
+		return fmt.Errorf("invalid state transition from %q to %q", c.State, to)
+	}
+
+	c.History = append(c.History, Transition{
+		From:   c.State,
+		To:     to,
+		At:     time.Now().UTC(),
+		Reason: reason,
+	})
+	c.State = to
+	return nil
+}
@@ -0,0 +1,42 @@
+package mixer
+
+import (
+	"testing"
+)
+
+func TestMixer_Transition(t *testing.T) {
+	customer := CustomerData{}
+	for _, state := range []State{AwaitingDeposit, Mixing, PayingOut, Completed} {
+		if err := customer.transition(state, ""); err != nil {
+			t.Fatalf("error moving to %s: %s", state, err)
+		}
+	}
+
+	if len(customer.History) != 4 {
+		t.Fatalf("expected 4 recorded transitions, got %d", len(customer.History))
+	}
+	if customer.History[1].From != AwaitingDeposit || customer.History[1].To != Mixing || customer.History[1].At.IsZero() {
+		t.Errorf("unexpected transition recorded %+v", customer.History[1])
+	}
+	if !customer.State.Final() {
+		t.Errorf("expected %s to be final", customer.State)
+	}
+
+	// a completed job cannot move anywhere else
+	if err := customer.transition(Failed, "too late"); err == nil {
+		t.Errorf("expected moving a completed job to fail")
+	}
+	if customer.State != Completed || len(customer.History) != 4 {
+		t.Errorf("a rejected transition must not change the customer")
+	}
+}
+
+func TestMixer_TransitionSkip(t *testing.T) {
+	customer := CustomerData{}
+	if err := customer.transition(AwaitingDeposit, ""); err != nil {
+		t.Fatalf("error moving to %s: %s", AwaitingDeposit, err)
+	}
+	if err := customer.transition(Completed, ""); err == nil {
+		t.Errorf("expected skipping straight to completed to fail")
+	}
+}
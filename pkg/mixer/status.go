@@ -0,0 +1,90 @@
+package mixer
+
+import (
+	"fmt"
+	"github.com/Denton24646/gtumbler/pkg/crypto"
+	"github.com/Denton24646/gtumbler/pkg/models"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// statusPath is the prefix of the status endpoint, the rest of the path is the customer id
+const statusPath = "/status/"
+
+// Status is the GET /status/{id} endpoint for the mixer - it reports where a customer job is
+// The job is only looked up by the private random id of the customer: deposit addresses are public on the ledger, so
+// looking jobs up by them would let anyone link a deposit to its clean addresses
+func (m *Mixer) Status(w http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodGet {
+		writeError(w, http.StatusMethodNotAllowed, models.ErrMethodNotAllowed, "use GET to check the status of a customer")
+		return
+	}
+
+	key := strings.TrimPrefix(req.URL.Path, statusPath)
+	id, err := strconv.Atoi(key)
+	if err != nil {
+		writeError(w, http.StatusNotFound, models.ErrNotFound, fmt.Sprintf("no customer with id %q", key))
+		return
+	}
+	customer, ok := m.customer(id)
+	if !ok {
+		writeError(w, http.StatusNotFound, models.ErrNotFound, fmt.Sprintf("no customer with id %d", id))
+		return
+	}
+
+	writeJSON(w, http.StatusOK, status(id, customer))
+}
+
+// status summarises a customer record for the client
+func status(id int, customer CustomerData) *models.StatusResponse {
+	response := &models.StatusResponse{
+		Id:             id,
+		State:          string(customer.State),
+		DepositAddress: customer.DepositAddress,
+		Received:       customer.Received,
+		Fee:            customer.Quote.wire(customer.Received),
+		FeeAmount:      customer.FeeAmount,
+		PaidOut:        crypto.Zero,
+		Payouts:        make(map[crypto.Address]crypto.Amount),
+		Refunded:       crypto.Zero,
+		Refunds:        []models.RefundStatus{},
+		History:        []models.StateChange{},
+	}
+
+	// payout transfers follow the mix transfers in the plan, only the ones already sent count
+	for i := customer.Mixed; i < customer.Sent && i < len(customer.Transfers); i++ {
+		transfer := customer.Transfers[i]
+		response.PaidOut = response.PaidOut.Add(transfer.Amount)
+		response.Payouts[transfer.To] = response.Payouts[transfer.To].Add(transfer.Amount)
+	}
+
+	if customer.Sent < len(customer.Transfers) {
+		next := customer.Transfers[customer.Sent].At
+		response.NextSend = &next
+	}
+
+	for _, refund := range customer.Refunds {
+		if refund.Sent {
+			response.Refunded = response.Refunded.Add(refund.Deposit.Amount)
+		}
+		response.Refunds = append(response.Refunds, models.RefundStatus{
+			To:     refund.Deposit.From,
+			Amount: refund.Deposit.Amount,
+			Reason: refund.Reason,
+			Sent:   refund.Sent,
+			At:     refund.At,
+			Error:  refund.Error,
+		})
+	}
+
+	for _, transition := range customer.History {
+		response.History = append(response.History, models.StateChange{
+			State:  string(transition.To),
+			At:     transition.At,
+			Reason: transition.Reason,
+		})
+	}
+
+	return response
+}
@@ -0,0 +1,66 @@
+package mixer
+
+import (
+	"encoding/json"
+	"github.com/Denton24646/gtumbler/pkg/crypto"
+	"github.com/Denton24646/gtumbler/pkg/mixer/tumbler"
+	"github.com/Denton24646/gtumbler/pkg/models"
+	"github.com/Denton24646/gtumbler/pkg/store"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestMixer_Status(t *testing.T) {
+	testMixer := New(newTestLedger(t), store.NewMemory())
+	customer := CustomerData{
+		CleanAddresses: []crypto.Address{"Clean1", "Clean2"},
+		DepositAddress: "Deposit",
+		Received:       crypto.MustParseAmount("2"),
+		Transfers: []tumbler.Transfer{
+			{From: "Deposit", To: "House1", Amount: crypto.MustParseAmount("2")},
+			{From: "House2", To: "Clean1", Amount: crypto.MustParseAmount("0.5")},
+			{From: "House3", To: "Clean1", Amount: crypto.MustParseAmount("0.25")},
+			{From: "House4", To: "Clean2", Amount: crypto.MustParseAmount("1.25")},
+		},
+		Mixed: 1,
+		Sent:  3,
+	}
+	for _, state := range []State{AwaitingDeposit, Mixing, PayingOut} {
+		if err := customer.transition(state, ""); err != nil {
+			t.Fatalf("error moving to %s: %s", state, err)
+		}
+	}
+	if err := testMixer.saveCustomer(42, customer); err != nil {
+		t.Fatalf("error saving customer: %s", err)
+	}
+
+	recorder := httptest.NewRecorder()
+	testMixer.Status(recorder, httptest.NewRequest(http.MethodGet, "/status/42", nil))
+	if recorder.Code != http.StatusOK {
+		t.Fatalf("expected status 200, got %d", recorder.Code)
+	}
+
+	response := &models.StatusResponse{}
+	if err := json.Unmarshal(recorder.Body.Bytes(), response); err != nil {
+		t.Fatalf("error parsing status: %s", err)
+	}
+	if response.Id != 42 || response.State != string(PayingOut) || len(response.History) != 3 {
+		t.Errorf("unexpected status %+v", response)
+	}
+	if response.PaidOut != crypto.MustParseAmount("0.75") {
+		t.Errorf("expected 0.75 paid out, got %s", response.PaidOut)
+	}
+	if response.Payouts["Clean1"] != crypto.MustParseAmount("0.75") || len(response.Payouts) != 1 {
+		t.Errorf("unexpected payouts %v", response.Payouts)
+	}
+
+	// unknown ids are not found, and neither are deposit addresses, which are public on the ledger
+	for _, key := range []string{"43", "Deposit"} {
+		recorder := httptest.NewRecorder()
+		testMixer.Status(recorder, httptest.NewRequest(http.MethodGet, "/status/"+key, nil))
+		if recorder.Code != http.StatusNotFound {
+			t.Errorf("expected status 404 for %s, got %d", key, recorder.Code)
+		}
+	}
+}
@@ -0,0 +1,141 @@
+package tumbler
+
+import (
+	"context"
+	"fmt"
+	"github.com/Denton24646/gtumbler/pkg/crypto"
+	"math/rand"
+)
+
+// InsufficientLiquidityError is returned when planning a payout the house addresses cannot cover between them
+type InsufficientLiquidityError struct {
+	Available crypto.Amount
+	Needed    crypto.Amount
+}
+
+func (e *InsufficientLiquidityError) Error() string {
+	return fmt.Sprintf("house addresses hold %s, not enough to pay out %s", e.Available, e.Needed)
+}
+
+// Liquidity returns what each house address can spend: its balance on the ledger, minus what pending transfers
+// (planned but not sent yet) will move out of it, plus what the before transfers will move into it
+// Pending transfers belong to other jobs, nothing makes what they move into a house land before the payout being planned,
+// so only the before transfers (the ones sent ahead of the payout by the same job) count towards what a house will hold
+func (t *Tumbler) Liquidity(ctx context.Context, houses []crypto.Address, pending []Transfer, before []Transfer) (map[crypto.Address]crypto.Amount, error) {
+	available := make(map[crypto.Address]crypto.Amount)
+	for _, house := range houses {
+		if _, ok := available[house]; ok {
+			continue
+		}
+		balance, err := t.ledger.BalanceContext(ctx, house)
+		if err != nil {
+			return nil, err
+		}
+		available[house] = balance
+	}
+
+	for _, transfer := range pending {
+		if balance, ok := available[transfer.From]; ok {
+			available[transfer.From] = balance.Sub(transfer.Amount)
+		}
+	}
+	for _, transfer := range before {
+		if balance, ok := available[transfer.To]; ok {
+			available[transfer.To] = balance.Add(transfer.Amount)
+		}
+		if balance, ok := available[transfer.From]; ok {
+			available[transfer.From] = balance.Sub(transfer.Amount)
+		}
+	}
+	return available, nil
+}
+
+// totalLiquidity adds up what the houses can spend, houses that are already overdrawn by pending transfers count as empty
+func totalLiquidity(available map[crypto.Address]crypto.Amount) crypto.Amount {
+	total := crypto.Zero
+	for _, balance := range available {
+		if balance.IsPositive() {
+			total = total.Add(balance)
+		}
+	}
+	return total
+}
+
+// cover picks the houses a chunk is paid from and takes the chunk out of available
+// The house the strategy picked is used when it can cover the chunk, otherwise a random house that can,
+// and when no single house can the chunk is split across as many houses as it takes
+// Houses without uses left are passed over, whatever they hold
+func (t *Tumbler) cover(chunk Chunk, houses []crypto.Address, available map[crypto.Address]crypto.Amount) ([]Chunk, error) {
+	if t.usable(chunk.House) && available[chunk.House].Cmp(chunk.Amount) >= 0 {
+		available[chunk.House] = available[chunk.House].Sub(chunk.Amount)
+		t.use(chunk.House)
+		return []Chunk{chunk}, nil
+	}
+
+	var able []crypto.Address
+	for _, house := range houses {
+		if t.usable(house) && available[house].Cmp(chunk.Amount) >= 0 {
+			able = append(able, house)
+		}
+	}
+	if len(able) > 0 {
+		house := able[pickRandom(len(able))]
+		available[house] = available[house].Sub(chunk.Amount)
+		t.use(house)
+		return []Chunk{{House: house, Amount: chunk.Amount}}, nil
+	}
+
+	var covered []Chunk
+	remaining := chunk.Amount
+	for _, i := range rand.Perm(len(houses)) {
+		house := houses[i]
+		if !remaining.IsPositive() {
+			break
+		}
+		if !t.usable(house) || !available[house].IsPositive() {
+			continue
+		}
+		part := remaining
+		if available[house].Cmp(part) < 0 {
+			part = available[house]
+		}
+		available[house] = available[house].Sub(part)
+		t.use(house)
+		remaining = remaining.Sub(part)
+		covered = append(covered, Chunk{House: house, Amount: part})
+	}
+	if remaining.IsPositive() {
+		return nil, &InsufficientLiquidityError{Available: chunk.Amount.Sub(remaining), Needed: chunk.Amount}
+	}
+	return covered, nil
+}
+
+// usable reports whether house can take part in one more transfer
+func (t *Tumbler) usable(house crypto.Address) bool {
+	left, limited := t.Uses[house]
+	return !limited || left > 0
+}
+
+// use counts one transfer against the uses house has left
+func (t *Tumbler) use(house crypto.Address) {
+	if left, limited := t.Uses[house]; limited {
+		t.Uses[house] = left - 1
+	}
+}
+
+// usableHouse returns picked when it has uses left, otherwise a random house out of houses that does
+func (t *Tumbler) usableHouse(picked crypto.Address, houses []crypto.Address) (crypto.Address, bool) {
+	if t.usable(picked) {
+		return picked, true
+	}
+	var able []crypto.Address
+	for _, house := range houses {
+		if t.usable(house) {
+			able = append(able, house)
+		}
+	}
+	if len(able) == 0 {
+		return "", false
+	}
+	return able[pickRandom(len(able))], true
+}
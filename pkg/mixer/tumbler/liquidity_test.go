@@ -0,0 +1,151 @@
+package tumbler
+
+import (
+	"context"
+	"fmt"
+	"github.com/Denton24646/gtumbler/pkg/crypto"
+	"testing"
+)
+
+// newLowLedger returns an in-memory ledger whose house addresses hold the given balances
+func newLowLedger(t *testing.T, balances map[crypto.Address]string) *crypto.MemoryLedger {
+	ledger := crypto.NewMemoryLedger()
+	for address, amount := range balances {
+		if err := ledger.Mint(address, crypto.MustParseAmount(amount)); err != nil {
+			t.Fatalf("error seeding address %s: %s", address, err)
+		}
+	}
+	return ledger
+}
+
+func TestTumbler_Liquidity(t *testing.T) {
+	ledger := newLowLedger(t, map[crypto.Address]string{"House1": "1", "House2": "2"})
+	testTumbler := New(crypto.MustParseAmount("1"), ledger)
+	// what other jobs still have to send into a house does not count, what they send out of it does
+	pending := []Transfer{
+		{From: "Other", To: "House1", Amount: crypto.MustParseAmount("0.5")},
+		{From: "House2", To: "Clean", Amount: crypto.MustParseAmount("1.5")},
+	}
+	before := []Transfer{
+		{From: "Deposit", To: "House3", Amount: crypto.MustParseAmount("0.25")},
+	}
+
+	available, err := testTumbler.Liquidity(context.Background(), []crypto.Address{"House1", "House2", "House3"}, pending, before)
+	if err != nil {
+		t.Fatalf("error checking liquidity: %s", err)
+	}
+	for house, expected := range map[crypto.Address]string{"House1": "1", "House2": "0.5", "House3": "0.25"} {
+		if available[house] != crypto.MustParseAmount(expected) {
+			t.Errorf("expected %s to have %s available, got %s", house, expected, available[house])
+		}
+	}
+}
+
+func TestTumbler_PlanPayoutLiquidity(t *testing.T) {
+	houses := []crypto.Address{"House1", "House2", "House3"}
+	tableTests := []struct {
+		balances map[crypto.Address]string
+		size     string
+		pending  []Transfer
+		before   []Transfer
+		ok       bool
+	}{
+		// plenty in every house
+		{map[crypto.Address]string{"House1": "10", "House2": "10", "House3": "10"}, "5", nil, nil, true},
+		// no single house can cover the payout, it has to be split across them
+		{map[crypto.Address]string{"House1": "2", "House2": "2", "House3": "2"}, "5.5", nil, nil, true},
+		// the houses together hold less than the payout
+		{map[crypto.Address]string{"House1": "1", "House2": "1", "House3": "1"}, "5", nil, nil, false},
+		// enough on the ledger, but already promised to another payout
+		{map[crypto.Address]string{"House1": "3", "House2": "3"}, "5",
+			[]Transfer{{From: "House1", To: "Other", Amount: crypto.MustParseAmount("2")}}, nil, false},
+		// short on the ledger, but the job's own deposit tops the houses up before the payout
+		{map[crypto.Address]string{"House1": "1"}, "5",
+			nil, []Transfer{{From: "Deposit", To: "House2", Amount: crypto.MustParseAmount("5")}}, true},
+		// short on the ledger, the deposit of another job may not land in time
+		{map[crypto.Address]string{"House1": "1"}, "5",
+			[]Transfer{{From: "Deposit", To: "House2", Amount: crypto.MustParseAmount("5")}}, nil, false},
+	}
+
+	for i, tt := range tableTests {
+		t.Run(fmt.Sprintf("test %d", i), func(t *testing.T) {
+			testTumbler := New(crypto.MustParseAmount(tt.size), newLowLedger(t, tt.balances))
+			testTumbler.Strategy = RandomStrategy{MinChunks: 1, MaxChunks: 3, MinChunk: crypto.MustParseAmount("0.1")}
+			testTumbler.Pending = tt.pending
+			testTumbler.Before = tt.before
+			transfers, err := testTumbler.PlanPayout(context.Background(), []crypto.Address{"Clean1", "Clean2"}, nil, houses)
+			if !tt.ok {
+				if _, ok := err.(*InsufficientLiquidityError); !ok {
+					t.Fatalf("record %d got %v, want an InsufficientLiquidityError", i, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("record %d got error %s", i, err)
+			}
+
+			// no house is planned to pay more than it will have
+			available, err := testTumbler.Liquidity(context.Background(), houses, tt.pending, tt.before)
+			if err != nil {
+				t.Fatalf("error checking liquidity: %s", err)
+			}
+			total := crypto.Zero
+			for _, transfer := range transfers {
+				available[transfer.From] = available[transfer.From].Sub(transfer.Amount)
+				total = total.Add(transfer.Amount)
+			}
+			for house, balance := range available {
+				if balance.Cmp(crypto.Zero) < 0 {
+					t.Errorf("record %d overdraws %s by %s", i, house, balance)
+				}
+			}
+			if total.Cmp(testTumbler.Size) != 0 {
+				t.Errorf("record %d pays out %s, want %s", i, total, testTumbler.Size)
+			}
+		})
+	}
+}
+
+func TestTumbler_PlanUses(t *testing.T) {
+	houses := []crypto.Address{"House1", "House2", "House3"}
+	ledger := newLowLedger(t, map[crypto.Address]string{"House1": "10", "House2": "10", "House3": "10"})
+	testTumbler := New(crypto.MustParseAmount("2"), ledger)
+	testTumbler.Strategy = RandomStrategy{MinChunks: 5, MaxChunks: 5}
+	testTumbler.Uses = map[crypto.Address]int{"House1": 2, "House2": 1, "House3": 0}
+
+	// five chunks but three uses left between the houses, the deposit is sent in fewer chunks
+	mix, err := testTumbler.PlanMix("Deposit", houses)
+	if err != nil {
+		t.Fatalf("error planning mix: %s", err)
+	}
+	total := crypto.Zero
+	count := make(map[crypto.Address]int)
+	for _, transfer := range mix {
+		total = total.Add(transfer.Amount)
+		count[transfer.To]++
+	}
+	if len(mix) != 3 || count["House1"] != 2 || count["House2"] != 1 || total != crypto.MustParseAmount("2") {
+		t.Errorf("expected 2 coins sent in 3 transfers to House1 and House2, got %v", mix)
+	}
+
+	// nothing is left for the payout, and the failed plan gives nothing back or away
+	_, err = testTumbler.PlanPayout(context.Background(), []crypto.Address{"Clean"}, nil, houses)
+	if _, ok := err.(*InsufficientLiquidityError); !ok {
+		t.Fatalf("got %v, want an InsufficientLiquidityError", err)
+	}
+	for house, left := range testTumbler.Uses {
+		if left != 0 {
+			t.Errorf("expected %s to have no uses left, got %d", house, left)
+		}
+	}
+
+	testTumbler.Uses["House3"] = 1
+	testTumbler.Strategy = RandomStrategy{MinChunks: 1, MaxChunks: 1}
+	payout, err := testTumbler.PlanPayout(context.Background(), []crypto.Address{"Clean"}, nil, houses)
+	if err != nil {
+		t.Fatalf("error planning payout: %s", err)
+	}
+	if len(payout) != 1 || payout[0].From != "House3" || testTumbler.Uses["House3"] != 0 {
+		t.Errorf("expected a single payout from House3, got %v", payout)
+	}
+}
@@ -0,0 +1,49 @@
+package tumbler
+
+import (
+	"context"
+	"math/rand"
+	"sort"
+	"time"
+)
+
+// Schedule spreads transfers over a random window: each transfer gets a random time between start and start+window
+// at which it should be sent. The times are sorted so the transfers keep their order, which means coins still reach
+// the house addresses before they are paid out. Sending coins back to back makes it trivial to match a payout to its
+// deposit by timing, spreading them out makes that much harder
+// The schedule is part of the transfers themselves, so it is saved and resumed along with the rest of the plan
+func Schedule(transfers []Transfer, start time.Time, window time.Duration) []Transfer {
+	offsets := make([]time.Duration, len(transfers))
+	if window > 0 {
+		for i := range offsets {
+			offsets[i] = time.Duration(rand.Int63n(int64(window) + 1))
+		}
+		sort.Slice(offsets, func(i, j int) bool {
+			return offsets[i] < offsets[j]
+		})
+	}
+
+	scheduled := make([]Transfer, len(transfers))
+	for i, transfer := range transfers {
+		transfer.At = start.Add(offsets[i])
+		scheduled[i] = transfer
+	}
+	return scheduled
+}
+
+// wait blocks until the transfer is due, transfers without a scheduled time are due right away
+// It gives up with the error of ctx when ctx is done first
+func wait(ctx context.Context, transfer Transfer) error {
+	delay := time.Until(transfer.At)
+	if delay <= 0 {
+		return ctx.Err()
+	}
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
@@ -0,0 +1,97 @@
+package tumbler
+
+import (
+	"context"
+	"github.com/Denton24646/gtumbler/pkg/crypto"
+	"testing"
+	"time"
+)
+
+func TestTumbler_Schedule(t *testing.T) {
+	var transfers []Transfer
+	for i := 0; i < 20; i++ {
+		transfers = append(transfers, Transfer{From: "Genesis", To: "House1", Amount: crypto.AmountFromUnits(int64(i + 1))})
+	}
+
+	start := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	window := time.Hour
+	scheduled := Schedule(transfers, start, window)
+	if len(scheduled) != len(transfers) {
+		t.Fatalf("expected %d scheduled transfers, got %d", len(transfers), len(scheduled))
+	}
+	for i, transfer := range scheduled {
+		if transfer.Amount != transfers[i].Amount {
+			t.Errorf("transfer %d moved, got %s want %s", i, transfer.Amount, transfers[i].Amount)
+		}
+		if transfer.At.Before(start) || transfer.At.After(start.Add(window)) {
+			t.Errorf("transfer %d scheduled at %s, outside of the window", i, transfer.At)
+		}
+		if i > 0 && transfer.At.Before(scheduled[i-1].At) {
+			t.Errorf("transfer %d scheduled before the transfer preceding it", i)
+		}
+	}
+	if !transfers[0].At.IsZero() {
+		t.Errorf("scheduling must not change the transfers passed in")
+	}
+
+	// without a window everything is due right away
+	for i, transfer := range Schedule(transfers, start, 0) {
+		if !transfer.At.Equal(start) {
+			t.Errorf("transfer %d scheduled at %s, want %s", i, transfer.At, start)
+		}
+	}
+}
+
+func TestTumbler_ExecuteScheduled(t *testing.T) {
+	ledger := newTestLedger(t)
+	testTumbler := New(crypto.MustParseAmount("1"), ledger)
+
+	transfers := []Transfer{
+		{From: "Genesis", To: "House1", Amount: crypto.MustParseAmount("0.5")},
+		{From: "Genesis", To: "House2", Amount: crypto.MustParseAmount("0.5")},
+	}
+	start := time.Now()
+	transfers = Schedule(transfers, start, 50*time.Millisecond)
+
+	var sentAt []time.Time
+	err := testTumbler.Execute(transfers, 0, func(sent int) error {
+		sentAt = append(sentAt, time.Now())
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("error executing transfers: %s", err)
+	}
+	for i, at := range sentAt {
+		if at.Before(transfers[i].At) {
+			t.Errorf("transfer %d sent at %s, before it was due at %s", i, at, transfers[i].At)
+		}
+	}
+}
+
+func TestTumbler_ExecuteCancelled(t *testing.T) {
+	ledger := newTestLedger(t)
+	testTumbler := New(crypto.MustParseAmount("1"), ledger)
+
+	transfers := []Transfer{
+		{From: "Genesis", To: "House1", Amount: crypto.MustParseAmount("0.5")},
+		{From: "Genesis", To: "House2", Amount: crypto.MustParseAmount("0.5"), At: time.Now().Add(time.Hour)},
+	}
+	before, _ := ledger.Balance("House2")
+	ctx, cancel := context.WithCancel(context.Background())
+	sent := 0
+	err := testTumbler.ExecuteContext(ctx, transfers, 0, func(done int) error {
+		sent = done
+		// stop while the second transfer is still an hour away
+		cancel()
+		return nil
+	})
+	if err != context.Canceled {
+		t.Fatalf("expected the execution to stop with %s, got %v", context.Canceled, err)
+	}
+	if sent != 1 {
+		t.Errorf("expected 1 transfer sent before stopping, got %d", sent)
+	}
+	if after, _ := ledger.Balance("House2"); after != before {
+		t.Errorf("expected nothing sent to House2 after cancelling, it went from %s to %s", before, after)
+	}
+}
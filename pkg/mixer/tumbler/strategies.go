@@ -1,12 +1,30 @@
 package tumbler
 
-import "math/rand"
+import (
+	"fmt"
+	"github.com/Denton24646/gtumbler/pkg/crypto"
+	"math"
+	"math/rand"
+)
+
+// ratioPrecision is the resolution used when applying a strategy chunk to an amount
+const ratioPrecision = 1000000
+
+// Strategy decides how the tumbler chunks up an amount and which house address each chunk goes through
+type Strategy interface {
+	// Plan splits amount into chunks that add up to exactly amount, each assigned to one of houses
+	Plan(amount crypto.Amount, houses []crypto.Address) ([]Chunk, error)
+}
+
+// Chunk is a part of the amount being tumbled and the house address it moves through
+type Chunk struct {
+	House  crypto.Address
+	Amount crypto.Amount
+}
 
 // strategies is an array containing different ways of chunking up an amount of cryptocurrency
 // for example one strategy is [0.5, 0.5] which represents cutting up the amount into halves
-// ideally the strategies would not be predetermined but determined at runtime, but this comes at the cost of
-// additional complexity, performance, and rounding errors. so for now only a set number of strategies are used by the tumbler
-// the strategies are limited by the number of pre-seeded house accounts (5)
+// it is the fixed Strategy, RandomStrategy generates the ratios at runtime instead
 type strategies map[int][]float64
 
 func getStrategies() *strategies {
@@ -21,6 +39,187 @@ func getStrategies() *strategies {
 	return s
 }
 
+// NewFixedStrategy returns the Strategy picking one of the predetermined ratio tables at random
+func NewFixedStrategy() Strategy {
+	return getStrategies()
+}
+
+// Plan splits amount by a random table of ratios and sends each chunk through a random house
+func (s *strategies) Plan(amount crypto.Amount, houses []crypto.Address) ([]Chunk, error) {
+	if len(*s) == 0 {
+		return nil, fmt.Errorf("no strategies to pick from")
+	}
+	strategy := (*s)[pickRandom(len(*s))]
+
+	chunks, err := split(amount, strategy)
+	if err != nil {
+		return nil, err
+	}
+	return assign(chunks, houses)
+}
+
+// RandomStrategy generates a new split for every plan: the number of chunks is picked between MinChunks and MaxChunks
+// and the ratios are drawn from a flat Dirichlet distribution, so every way of cutting up the amount is equally likely
+// Every chunk is at least MinChunk, fewer chunks are used when the amount is too small for that
+type RandomStrategy struct {
+	MinChunks int
+	MaxChunks int
+	MinChunk  crypto.Amount
+}
+
+// Plan splits amount into random chunks and sends each chunk through a random house
+func (r RandomStrategy) Plan(amount crypto.Amount, houses []crypto.Address) ([]Chunk, error) {
+	if r.MinChunks < 1 || r.MaxChunks < r.MinChunks {
+		return nil, fmt.Errorf("random strategy needs 1 <= minimum chunks <= maximum chunks, got %d and %d",
+			r.MinChunks, r.MaxChunks)
+	}
+
+	count := r.MinChunks + rand.Intn(r.MaxChunks-r.MinChunks+1)
+	if r.MinChunk.IsPositive() {
+		if most := amount.Units() / r.MinChunk.Units(); most < int64(count) {
+			count = int(most)
+		}
+	}
+	if count < 1 {
+		count = 1
+	}
+
+	// every chunk gets MinChunk, the spare coins are divided by the random ratios
+	// ratios are rounded down so the last chunk absorbs the remainder and never goes below MinChunk
+	floor := crypto.Zero
+	if int64(count)*r.MinChunk.Units() <= amount.Units() {
+		floor = r.MinChunk
+	}
+	spare := amount.Sub(crypto.AmountFromUnits(int64(count) * floor.Units()))
+	ratios := dirichlet(count)
+	var chunks []crypto.Amount
+	remaining := amount
+	for i, ratio := range ratios {
+		sendAmount := remaining
+		if i < len(ratios)-1 {
+			sendAmount = floor.Add(spare.MulRatio(int64(ratio*ratioPrecision), ratioPrecision))
+		}
+		if sendAmount.IsZero() {
+			continue
+		}
+		chunks = append(chunks, sendAmount)
+		remaining = remaining.Sub(sendAmount)
+	}
+
+	err := verifySplit(amount, chunks)
+	if err != nil {
+		return nil, err
+	}
+	return assign(chunks, houses)
+}
+
+// dirichlet draws count ratios adding up to one from a flat Dirichlet distribution, by normalizing exponential samples
+func dirichlet(count int) []float64 {
+	ratios := make([]float64, count)
+	total := 0.0
+	for i := range ratios {
+		ratios[i] = rand.ExpFloat64()
+		total += ratios[i]
+	}
+	for i := range ratios {
+		ratios[i] /= total
+	}
+	return ratios
+}
+
+// assign sends every chunk through a random house address
+// Chunks go through as many different houses as there are, a house is only used twice once every house has a chunk
+func assign(amounts []crypto.Amount, houses []crypto.Address) ([]Chunk, error) {
+	if len(houses) == 0 {
+		return nil, fmt.Errorf("no house addresses to tumble through")
+	}
+	chunks := make([]Chunk, 0, len(amounts))
+	var order []int
+	for _, amount := range amounts {
+		if len(order) == 0 {
+			order = rand.Perm(len(houses))
+		}
+		chunks = append(chunks, Chunk{House: houses[order[0]], Amount: amount})
+		order = order[1:]
+	}
+	return chunks, nil
+}
+
+// StrategyConfig selects and configures a strategy, MinChunk is parsed with crypto.ParseAmount
+type StrategyConfig struct {
+	// Kind is either fixed or random
+	Kind      string `cfgDefault:"fixed"`
+	MinChunks int    `cfgDefault:"2"`
+	MaxChunks int    `cfgDefault:"8"`
+	MinChunk  string `cfgDefault:"0.01"`
+}
+
+// NewStrategy builds the strategy described by config
+func NewStrategy(config StrategyConfig) (Strategy, error) {
+	switch config.Kind {
+	case "fixed":
+		return NewFixedStrategy(), nil
+	case "random":
+		minChunk, err := crypto.ParseAmount(config.MinChunk)
+		if err != nil {
+			return nil, fmt.Errorf("minimum chunk: %s", err)
+		}
+		if config.MinChunks < 1 || config.MaxChunks < config.MinChunks {
+			return nil, fmt.Errorf("random strategy needs 1 <= minimum chunks <= maximum chunks, got %d and %d",
+				config.MinChunks, config.MaxChunks)
+		}
+		return RandomStrategy{MinChunks: config.MinChunks, MaxChunks: config.MaxChunks, MinChunk: minChunk}, nil
+	default:
+		return nil, fmt.Errorf("unknown strategy %q, use fixed or random", config.Kind)
+	}
+}
+
 func pickRandom(number int) int {
 	return rand.Int() % number
 }
+
+// chunkOf returns the part of amount described by a strategy chunk, for example chunk 0.2 of 5 coins is 1 coin
+// the chunk is turned into an exact ratio first so the amount itself never goes through floating point
+func chunkOf(amount crypto.Amount, chunk float64) crypto.Amount {
+	return amount.MulRatio(int64(math.Round(chunk*ratioPrecision)), ratioPrecision)
+}
+
+// split divides amount into the chunks described by strategy
+// every chunk but the last is rounded down and the last chunk absorbs the remainder, so no dust is left behind
+// chunks that round down to nothing are dropped since the ledger cannot send zero coins
+func split(amount crypto.Amount, strategy []float64) ([]crypto.Amount, error) {
+	var chunks []crypto.Amount
+	remaining := amount
+	for i, chunk := range strategy {
+		sendAmount := remaining
+		if i < len(strategy)-1 {
+			sendAmount = chunkOf(amount, chunk)
+		}
+		if sendAmount.IsZero() {
+			continue
+		}
+		chunks = append(chunks, sendAmount)
+		remaining = remaining.Sub(sendAmount)
+	}
+
+	err := verifySplit(amount, chunks)
+	if err != nil {
+		return nil, err
+	}
+	return chunks, nil
+}
+
+// verifySplit checks that every chunk is positive and that the chunks add up to exactly amount
+func verifySplit(amount crypto.Amount, chunks []crypto.Amount) error {
+	total := crypto.Zero
+	for i, chunk := range chunks {
+		if !chunk.IsPositive() {
+			return fmt.Errorf("chunk %d of split is %s, chunks must be positive", i, chunk)
+		}
+		total = total.Add(chunk)
+	}
+	if total != amount {
+		return fmt.Errorf("split adds up to %s instead of %s", total, amount)
+	}
+	return nil
+}
@@ -1,6 +1,8 @@
 package tumbler
 
 import (
+	"fmt"
+	"github.com/Denton24646/gtumbler/pkg/crypto"
 	"reflect"
 	"testing"
 )
@@ -35,3 +37,181 @@ func TestTumbler_PickRandom(t *testing.T) {
 
 	t.Errorf("expected a number from %v, received %d", expected, got)
 }
+
+func TestTumbler_ChunkOf(t *testing.T) {
+	tableTests := []struct {
+		amount   string
+		chunk    float64
+		expected string
+	}{
+		{"1", 0.5, "0.5"},
+		{"5", 0.2, "1"},
+		{"1.1", 0.8, "0.88"},
+		{"0.3", 0.4, "0.12"},
+	}
+
+	for i, tt := range tableTests {
+		t.Run(fmt.Sprintf("test %d", i), func(t *testing.T) {
+			got := chunkOf(crypto.MustParseAmount(tt.amount), tt.chunk)
+			if got != crypto.MustParseAmount(tt.expected) {
+				t.Errorf("record %d got %s, want %s", i, got, tt.expected)
+			}
+		})
+	}
+}
+
+func TestTumbler_Split(t *testing.T) {
+	tableTests := []struct {
+		amount   string
+		strategy []float64
+		expected []string
+	}{
+		{"1", []float64{0.5, 0.5}, []string{"0.5", "0.5"}},
+		// a third of a coin cannot be represented exactly, the last chunk absorbs the remainder
+		{"1", []float64{0.333333, 0.333333, 0.333334}, []string{"0.333333", "0.333333", "0.333334"}},
+		{"0.00000001", []float64{0.5, 0.5}, []string{"0.00000001"}},
+		{"1.00000001", []float64{0.2, 0.2, 0.2, 0.2, 0.2}, []string{"0.2", "0.2", "0.2", "0.2", "0.20000001"}},
+	}
+
+	for i, tt := range tableTests {
+		t.Run(fmt.Sprintf("test %d", i), func(t *testing.T) {
+			chunks, err := split(crypto.MustParseAmount(tt.amount), tt.strategy)
+			if err != nil {
+				t.Fatalf("record %d got error %s", i, err)
+			}
+			var got []string
+			for _, chunk := range chunks {
+				got = append(got, chunk.String())
+			}
+			if !reflect.DeepEqual(got, tt.expected) {
+				t.Errorf("record %d got %v, want %v", i, got, tt.expected)
+			}
+		})
+	}
+}
+
+func TestTumbler_SplitOverdraw(t *testing.T) {
+	// a strategy whose ratios add up to more than the whole would overdraw the deposit address
+	_, err := split(crypto.MustParseAmount("1"), []float64{0.8, 0.8, 0.2})
+	if err == nil {
+		t.Errorf("expected an error splitting more than the amount")
+	}
+}
+
+func TestRandomStrategy_Plan(t *testing.T) {
+	houses := []crypto.Address{"House1", "House2", "House3"}
+	tableTests := []struct {
+		strategy  RandomStrategy
+		amount    string
+		minChunks int
+		maxChunks int
+	}{
+		{RandomStrategy{MinChunks: 2, MaxChunks: 8, MinChunk: crypto.MustParseAmount("0.01")}, "4", 2, 8},
+		{RandomStrategy{MinChunks: 1, MaxChunks: 1}, "1.23456789", 1, 1},
+		// the amount is only enough for three chunks of the minimum size
+		{RandomStrategy{MinChunks: 5, MaxChunks: 10, MinChunk: crypto.MustParseAmount("0.1")}, "0.35", 3, 3},
+		// too small for a single chunk of the minimum size, it is sent whole
+		{RandomStrategy{MinChunks: 2, MaxChunks: 4, MinChunk: crypto.MustParseAmount("1")}, "0.5", 1, 1},
+		{RandomStrategy{MinChunks: 3, MaxChunks: 3}, "0.00000002", 1, 3},
+	}
+
+	for i, tt := range tableTests {
+		t.Run(fmt.Sprintf("test %d", i), func(t *testing.T) {
+			amount := crypto.MustParseAmount(tt.amount)
+			for run := 0; run < 50; run++ {
+				chunks, err := tt.strategy.Plan(amount, houses)
+				if err != nil {
+					t.Fatalf("record %d got error %s", i, err)
+				}
+				if len(chunks) < tt.minChunks || len(chunks) > tt.maxChunks {
+					t.Fatalf("record %d got %d chunks, want between %d and %d", i, len(chunks), tt.minChunks, tt.maxChunks)
+				}
+
+				total := crypto.Zero
+				for _, chunk := range chunks {
+					if len(chunks) > 1 && chunk.Amount.Cmp(tt.strategy.MinChunk) < 0 {
+						t.Errorf("record %d chunk %s is below the minimum %s", i, chunk.Amount, tt.strategy.MinChunk)
+					}
+					if chunk.House != "House1" && chunk.House != "House2" && chunk.House != "House3" {
+						t.Errorf("record %d chunk sent through unknown house %s", i, chunk.House)
+					}
+					total = total.Add(chunk.Amount)
+				}
+				if total != amount {
+					t.Fatalf("record %d chunks add up to %s, want %s", i, total, amount)
+				}
+			}
+		})
+	}
+}
+
+func TestTumbler_FixedStrategyPlan(t *testing.T) {
+	amount := crypto.MustParseAmount("3")
+	chunks, err := NewFixedStrategy().Plan(amount, []crypto.Address{"House1"})
+	if err != nil {
+		t.Fatalf("error planning: %s", err)
+	}
+	total := crypto.Zero
+	for _, chunk := range chunks {
+		if chunk.House != "House1" {
+			t.Errorf("chunk sent through unknown house %s", chunk.House)
+		}
+		total = total.Add(chunk.Amount)
+	}
+	if total != amount {
+		t.Errorf("chunks add up to %s, want %s", total, amount)
+	}
+
+	if _, err := NewFixedStrategy().Plan(amount, nil); err == nil {
+		t.Errorf("expected an error planning without house addresses")
+	}
+}
+
+func TestNewStrategy(t *testing.T) {
+	tableTests := []struct {
+		config StrategyConfig
+		valid  bool
+	}{
+		{StrategyConfig{Kind: "fixed"}, true},
+		{StrategyConfig{Kind: "random", MinChunks: 2, MaxChunks: 8, MinChunk: "0.01"}, true},
+		{StrategyConfig{Kind: "random", MinChunks: 0, MaxChunks: 8, MinChunk: "0.01"}, false},
+		{StrategyConfig{Kind: "random", MinChunks: 4, MaxChunks: 2, MinChunk: "0.01"}, false},
+		{StrategyConfig{Kind: "random", MinChunks: 2, MaxChunks: 8, MinChunk: "some"}, false},
+		{StrategyConfig{Kind: "clever"}, false},
+	}
+
+	for i, tt := range tableTests {
+		t.Run(fmt.Sprintf("test %d", i), func(t *testing.T) {
+			_, err := NewStrategy(tt.config)
+			if (err == nil) != tt.valid {
+				t.Errorf("record %d got error %v, want valid %t", i, err, tt.valid)
+			}
+		})
+	}
+}
+
+func TestTumbler_AssignSpread(t *testing.T) {
+	var houses []crypto.Address
+	for i := 0; i < 12; i++ {
+		houses = append(houses, crypto.Address(fmt.Sprintf("House%d", i)))
+	}
+	amounts := make([]crypto.Amount, 30)
+	for i := range amounts {
+		amounts[i] = crypto.MustParseAmount("0.1")
+	}
+
+	chunks, err := assign(amounts, houses)
+	if err != nil {
+		t.Fatalf("error assigning chunks: %s", err)
+	}
+	// every house gets a chunk before any house gets a third one
+	used := make(map[crypto.Address]int)
+	for _, chunk := range chunks {
+		used[chunk.House]++
+	}
+	for _, house := range houses {
+		if used[house] < 2 || used[house] > 3 {
+			t.Errorf("expected %s to get 2 or 3 of the 30 chunks, got %d", house, used[house])
+		}
+	}
+}
@@ -1,9 +1,11 @@
 package tumbler
 
 import (
+	"context"
 	"fmt"
 	"github.com/Denton24646/gtumbler/pkg/crypto"
-	"strconv"
+	"math/rand"
+	"time"
 )
 
 // tumbler is responsible for the actual mixing process
@@ -18,8 +20,19 @@ import (
 // 2. it must send them in random sizes
 // 3. report the final tumbling process as complete
 
-const minDeposit = 0.1
-const maxDeposit = 10
+// Limits bounds the size of a deposit the tumbler accepts, both ends excluded
+// This keeps any single deposit small next to what the house addresses hold, the mixer measures the liquidity itself
+// and turns away jobs the house addresses cannot pay out
+type Limits struct {
+	Min crypto.Amount
+	Max crypto.Amount
+}
+
+// DefaultLimits are used unless the mixer is configured otherwise
+var DefaultLimits = Limits{
+	Min: crypto.MustParseAmount("0.1"),
+	Max: crypto.MustParseAmount("10"),
+}
 
 type Tumble interface {
 	// Mix mixes the client coins from the deposit address back to various house addresses
@@ -30,13 +43,43 @@ type Tumble interface {
 
 type Tumbler struct {
 	Size crypto.Amount
-	Strategies *strategies
+	// Strategy decides how Size is chunked up, by default one of the fixed strategies is picked at random
+	Strategy Strategy
+	// Limits is what Mix validates the size against
+	Limits Limits
+	// Pending are transfers of other jobs planned but not sent yet, what they move out of the houses is counted in when
+	// checking what the houses can pay out. Before are the transfers the same job sends ahead of its payout, which are
+	// counted in both ways
+	Pending []Transfer
+	Before  []Transfer
+	// Uses (when not nil) is how many more transfers each house address may take part in, planning never puts a house
+	// in more transfers than it has left and counts down what it used. Houses missing from it are not limited
+	Uses map[crypto.Address]int
+	// Mark (when not nil) is called by Execute right before every send, to record it as Sending
+	// Sending is the mark an earlier Execute left behind when it stopped, the marked transfer is only sent again
+	// when the ledger shows it did not go through
+	Mark    func(sending Sending) error
+	Sending *Sending
+	// ledger is where the tumbler moves coins between addresses
+	ledger crypto.Ledger
 }
 
-func New(amount crypto.Amount) *Tumbler {
+// Transfer is a single planned movement of coins
+// The tumbler plans every transfer before moving any coins so the plan can be stored and resumed after a restart
+// At is when the transfer is scheduled to be sent (see Schedule), a zero At means as soon as possible
+type Transfer struct {
+	From   crypto.Address `json:"from"`
+	To     crypto.Address `json:"to"`
+	Amount crypto.Amount  `json:"amount"`
+	At     time.Time      `json:"at"`
+}
+
+func New(amount crypto.Amount, ledger crypto.Ledger) *Tumbler {
 	return &Tumbler{
-		Size: amount,
-		Strategies: getStrategies(),
+		Size:     amount,
+		Strategy: NewFixedStrategy(),
+		Limits:   DefaultLimits,
+		ledger:   ledger,
 	}
 }
 
@@ -44,39 +87,71 @@ func New(amount crypto.Amount) *Tumbler {
 // It has information from the mixer about how many coins there are deposited
 // Then it uses some randomness to send those funds along to random houseAddresses
 func (t *Tumbler) Mix(depositAddress crypto.Address, houseAddresses []crypto.Address) error {
-	//parse amount, which is provided as a string, into a float
-	amount, err := strconv.ParseFloat(string(t.Size), 64)
+	return t.MixContext(context.Background(), depositAddress, houseAddresses)
+}
+
+// MixContext is Mix, but stops sending once ctx is done
+func (t *Tumbler) MixContext(ctx context.Context, depositAddress crypto.Address, houseAddresses []crypto.Address) error {
+	// validate amount deposited is valid
+	err := t.Limits.Check(t.Size)
 	if err != nil {
 		return err
 	}
-	// validate amount deposited is valid
-	if !valid(amount) {
-		return fmt.Errorf("funds are not within the specified guidelines for gtumbler")
+
+	transfers, err := t.PlanMix(depositAddress, houseAddresses)
+	if err != nil {
+		return err
 	}
+	return t.ExecuteContext(ctx, transfers, 0, nil)
+}
 
-	// pick random strategy from map
-	strategyKey := pickRandom(len(*t.Strategies))
-	strategy := (*t.Strategies)[strategyKey]
+// PlanMix plans the transfers Mix makes without moving any coins
+// Unlike Mix it does not validate the amount, the caller is expected to have checked the deposit with Limits.Check
+func (t *Tumbler) PlanMix(depositAddress crypto.Address, houseAddresses []crypto.Address) ([]Transfer, error) {
+	// plan the whole split up front so nothing is sent unless the chunks add up to the deposit exactly
+	chunks, err := t.Strategy.Plan(t.Size, houseAddresses)
+	if err != nil {
+		return nil, err
+	}
 
-	// send amount in strategy to random house address
-	// TODO use some time variability to add additional randomness
-	var sendAmount float64
-	for _, chunk := range strategy {
-		sendAmount = amount * chunk
-		houseKey := pickRandom(len(houseAddresses))
-		err := crypto.Send(depositAddress, houseAddresses[houseKey], crypto.Amount(fmt.Sprintf("%f", sendAmount)))
-		if err != nil {
-			return err
+	// send each chunk to the house address the strategy picked for it, or another one when it has no uses left
+	var transfers []Transfer
+	for _, chunk := range chunks {
+		house, ok := t.usableHouse(chunk.House, houseAddresses)
+		if !ok && len(transfers) == 0 {
+			return nil, fmt.Errorf("no house address has uses left to tumble %s through", t.Size)
+		}
+		if !ok {
+			// the chunk goes along with the one before it, the deposit is split in fewer chunks
+			last := &transfers[len(transfers)-1]
+			last.Amount = last.Amount.Add(chunk.Amount)
+			continue
 		}
+		t.use(house)
+		transfers = append(transfers, Transfer{
+			From:   depositAddress,
+			To:     house,
+			Amount: chunk.Amount,
+		})
 	}
 
-	return nil
+	return transfers, nil
+}
+
+// Check explains why a deposit is outside the limits, or returns nil when it can be mixed
+func (l Limits) Check(size crypto.Amount) error {
+	if l.valid(size) {
+		return nil
+	}
+	if size.Cmp(l.Min) <= 0 {
+		return fmt.Errorf("deposit of %s is too small, deposits must be more than %s", size, l.Min)
+	}
+	return fmt.Errorf("deposit of %s is too large, deposits must be less than %s", size, l.Max)
 }
 
 // Deposits need to be validated: they have a certain minimum and maximum size
-// This is to ensure the mixer has enough liquidity to mix all customer deposits
-func valid(size float64) bool {
-	if size > minDeposit && size < maxDeposit {
+func (l Limits) valid(size crypto.Amount) bool {
+	if size.Cmp(l.Min) > 0 && size.Cmp(l.Max) < 0 {
 		return true
 	}
 	return false
@@ -84,28 +159,192 @@ func valid(size float64) bool {
 
 // SendMixedFunds sends funds on the backend of the transaction, from random house addresses to the customer deposit addresses
 func (t *Tumbler) SendMixedFunds(customerAddresses []crypto.Address, houseAddresses []crypto.Address) error {
-	//parse amount, which is provided as a string, into a float
-	amount, err := strconv.ParseFloat(string(t.Size), 64)
+	return t.SendMixedFundsContext(context.Background(), customerAddresses, houseAddresses)
+}
+
+// SendMixedFundsContext is SendMixedFunds, but stops sending once ctx is done
+func (t *Tumbler) SendMixedFundsContext(ctx context.Context, customerAddresses []crypto.Address, houseAddresses []crypto.Address) error {
+	transfers, err := t.PlanPayout(ctx, customerAddresses, nil, houseAddresses)
 	if err != nil {
 		return err
 	}
+	return t.ExecuteContext(ctx, transfers, 0, nil)
+}
+
+// PlanPayout plans the transfers SendMixedFunds makes without moving any coins
+// Every customer address is guaranteed a share of the payout: percentages (one per address, adding up to 100) fix the
+// share of each address, without them the shares are random. Each share is then chunked up by the strategy and the
+// transfers of all addresses are shuffled together, so neither the amounts nor the order give the split away
+// Every chunk is paid from houses that can cover it (see Liquidity), and when the houses cannot cover the whole payout
+// between them planning fails with an InsufficientLiquidityError before any coins move
+// A payout that cannot be planned leaves Uses as it was, so it can be planned again through other houses
+func (t *Tumbler) PlanPayout(ctx context.Context, customerAddresses []crypto.Address, percentages []float64, houseAddresses []crypto.Address) ([]Transfer, error) {
+	var uses map[crypto.Address]int
+	if t.Uses != nil {
+		uses = make(map[crypto.Address]int, len(t.Uses))
+		for house, left := range t.Uses {
+			uses[house] = left
+		}
+	}
+	transfers, err := t.planPayout(ctx, customerAddresses, percentages, houseAddresses)
+	if err != nil {
+		t.Uses = uses
+	}
+	return transfers, err
+}
 
-	// pick random strategy from map
-	strategyKey := pickRandom(len(*t.Strategies))
-	strategy := (*t.Strategies)[strategyKey]
+func (t *Tumbler) planPayout(ctx context.Context, customerAddresses []crypto.Address, percentages []float64, houseAddresses []crypto.Address) ([]Transfer, error) {
+	shares, err := payoutShares(t.Size, len(customerAddresses), percentages)
+	if err != nil {
+		return nil, err
+	}
+
+	available, err := t.Liquidity(ctx, houseAddresses, t.Pending, t.Before)
+	if err != nil {
+		return nil, err
+	}
+	if total := totalLiquidity(available); total.Cmp(t.Size) < 0 {
+		return nil, &InsufficientLiquidityError{Available: total, Needed: t.Size}
+	}
 
-	// send funds from a random house address to a random customer address
-	// note: this does not ensure each address the customer specified will receive funds, for example one may receive all funds
-	var sendAmount float64
-	for _, chunk := range strategy {
-		sendAmount = amount * chunk
-		houseKey := pickRandom(len(houseAddresses))
-		customerKey := pickRandom(len(customerAddresses))
-		err := crypto.Send(houseAddresses[houseKey], customerAddresses[customerKey],  crypto.Amount(fmt.Sprintf("%f", sendAmount)))
+	var transfers []Transfer
+	for i, share := range shares {
+		chunks, err := t.Strategy.Plan(share, houseAddresses)
+		if err != nil {
+			return nil, err
+		}
+		for _, chunk := range chunks {
+			sources, err := t.cover(chunk, houseAddresses, available)
+			if err != nil {
+				return nil, err
+			}
+			for _, source := range sources {
+				transfers = append(transfers, Transfer{
+					From:   source.House,
+					To:     customerAddresses[i],
+					Amount: source.Amount,
+				})
+			}
+		}
+	}
+	rand.Shuffle(len(transfers), func(i, j int) {
+		transfers[i], transfers[j] = transfers[j], transfers[i]
+	})
+
+	return transfers, nil
+}
+
+// payoutShares divides amount between count addresses, by percentages when given or at random otherwise
+// Every address gets at least the smallest unit so none of them is left out, the rest is divided by the ratios
+// and the last address absorbs the remainder
+func payoutShares(amount crypto.Amount, count int, percentages []float64) ([]crypto.Amount, error) {
+	if count == 0 {
+		return nil, fmt.Errorf("no addresses to pay out to")
+	}
+	if amount.Units() < int64(count) {
+		return nil, fmt.Errorf("%s is too little to pay out to %d addresses", amount, count)
+	}
+
+	ratios := dirichlet(count)
+	if percentages != nil {
+		if len(percentages) != count {
+			return nil, fmt.Errorf("got %d percentages for %d addresses", len(percentages), count)
+		}
+		// the percentages are divided by their actual total, they may be a little off 100 and must not overdraw the amount
+		total := 0.0
+		for _, percentage := range percentages {
+			total += percentage
+		}
+		if total <= 0 {
+			return nil, fmt.Errorf("percentages %v do not add up to a positive total", percentages)
+		}
+		ratios = make([]float64, count)
+		for i, percentage := range percentages {
+			ratios[i] = percentage / total
+		}
+	}
+
+	unit := crypto.AmountFromUnits(1)
+	spare := amount.Sub(crypto.AmountFromUnits(int64(count)))
+	shares := make([]crypto.Amount, count)
+	remaining := amount
+	for i, ratio := range ratios {
+		share := remaining
+		if i < count-1 {
+			share = unit.Add(spare.MulRatio(int64(ratio*ratioPrecision), ratioPrecision))
+		}
+		shares[i] = share
+		remaining = remaining.Sub(share)
+	}
+
+	err := verifySplit(amount, shares)
+	if err != nil {
+		return nil, err
+	}
+	return shares, nil
+}
+
+// Sending marks a transfer that is about to be sent along with how many transactions its sender took part in before,
+// so whether the send went through can be told from the ledger even if the process stops right after sending
+type Sending struct {
+	Index int `json:"index"`
+	Seen  int `json:"seen"`
+}
+
+// Execute sends the planned transfers in order, starting with transfers[start], waiting for each one to be due
+// After each successful send progress (when not nil) is called with the number of transfers completed so far,
+// which lets the caller record how far it got and resume from there if the process stops
+// A transfer marked Sending by an earlier run that already shows up on the ledger is not sent a second time
+func (t *Tumbler) Execute(transfers []Transfer, start int, progress func(sent int) error) error {
+	return t.ExecuteContext(context.Background(), transfers, start, progress)
+}
+
+// ExecuteContext is Execute, but stops once ctx is done: a transfer that is not due yet is not waited for
+// and the error of ctx is returned, everything sent so far has gone through progress
+func (t *Tumbler) ExecuteContext(ctx context.Context, transfers []Transfer, start int, progress func(sent int) error) error {
+	for i := start; i < len(transfers); i++ {
+		transfer := transfers[i]
+		landed := false
+		if t.Sending != nil && t.Sending.Index == i {
+			var err error
+			landed, err = crypto.Landed(ctx, t.ledger, transfer.From, transfer.To, transfer.Amount, t.Sending.Seen)
+			if err != nil {
+				return err
+			}
+		}
+		if !landed {
+			err := t.send(ctx, i, transfer)
+			if err != nil {
+				return err
+			}
+		}
+		if progress == nil {
+			continue
+		}
+		err := progress(i + 1)
 		if err != nil {
 			return err
 		}
 	}
 
 	return nil
-}
\ No newline at end of file
+}
+
+// send sends transfer once it is due, marking it with Mark first
+func (t *Tumbler) send(ctx context.Context, i int, transfer Transfer) error {
+	err := wait(ctx, transfer)
+	if err != nil {
+		return err
+	}
+	if t.Mark != nil {
+		seen, err := crypto.Seen(ctx, t.ledger, transfer.From)
+		if err != nil {
+			return err
+		}
+		err = t.Mark(Sending{Index: i, Seen: seen})
+		if err != nil {
+			return err
+		}
+	}
+	return t.ledger.SendContext(ctx, transfer.From, transfer.To, transfer.Amount)
+}
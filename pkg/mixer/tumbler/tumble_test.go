@@ -1,37 +1,49 @@
 package tumbler
 
 import (
+	"context"
 	"fmt"
 	"github.com/Denton24646/gtumbler/pkg/crypto"
-	"strconv"
+	"reflect"
 	"testing"
 )
 
+// newTestLedger returns an in-memory ledger with a funded Genesis address and five funded house addresses
+func newTestLedger(t *testing.T) *crypto.MemoryLedger {
+	ledger := crypto.NewMemoryLedger()
+	for _, address := range []crypto.Address{"Genesis", "House1", "House2", "House3", "House4", "House5"} {
+		if err := ledger.Mint(address, crypto.MustParseAmount("10")); err != nil {
+			t.Fatalf("error seeding address %s: %s", address, err)
+		}
+	}
+	return ledger
+}
+
 func TestTumbler_ValidDeposit(t *testing.T) {
-	tableTests := []struct{
-		amount float64
-		valid bool
+	tableTests := []struct {
+		amount string
+		valid  bool
 	}{
-		{1.0, true},
-		{0.5, true},
-		{8, true},
-		{17, false},
-		{0, false},
-		{100, false},
+		{"1.0", true},
+		{"0.5", true},
+		{"8", true},
+		{"17", false},
+		{"0", false},
+		{"100", false},
 	}
 
 	for i, tt := range tableTests {
 		t.Run(fmt.Sprintf("test %d", i), func(t *testing.T) {
-			s := valid(tt.amount)
-			if s != tt.valid {
-				t.Errorf("record %d got %t, want %t", i, s, tt.valid)
+			err := DefaultLimits.Check(crypto.MustParseAmount(tt.amount))
+			if (err == nil) != tt.valid {
+				t.Errorf("record %d got %v, want valid %t", i, err, tt.valid)
 			}
 		})
 	}
 }
 
 func TestTumbler_Mix(t *testing.T) {
-	deposit := crypto.Amount("1.0")
+	deposit := crypto.MustParseAmount("1.0")
 	depositAddr := crypto.Address("Genesis")
 	houseAddr := []crypto.Address{
 		0: "House1",
@@ -40,11 +52,11 @@ func TestTumbler_Mix(t *testing.T) {
 		3: "House4",
 		4: "House5",
 	}
-	testTumbler := New(deposit)
+	ledger := newTestLedger(t)
+	testTumbler := New(deposit, ledger)
 	// check balance of deposit address before test
 	// we expect the balance to be lower by one after the test
-	// note: this had to be relaxed to be less than some amount because tests run concurrently
-	balance, err := crypto.CheckAddress(depositAddr)
+	balance, err := ledger.Balance(depositAddr)
 	if err != nil {
 		t.Errorf("error checking balance of address %s: %s", depositAddr, err)
 	}
@@ -53,22 +65,19 @@ func TestTumbler_Mix(t *testing.T) {
 		t.Errorf("error mixing coins: %s", err)
 	}
 
-	newBalance, err := crypto.CheckAddress(depositAddr)
+	newBalance, err := ledger.Balance(depositAddr)
 	if err != nil {
 		t.Errorf("error checking balance of address %s: %s", depositAddr, err)
 	}
 
-	b, _ :=  strconv.ParseFloat(string(balance), 64)
-	nb, _ := strconv.ParseFloat(string(newBalance), 64)
-	diff := nb - b
-
-	if diff > 0 {
-		t.Errorf("expected difference in deposit address of atleast %f, got %f", 1.0, diff)
+	diff := balance.Sub(newBalance)
+	if diff != deposit {
+		t.Errorf("expected difference in deposit address of %s, got %s", deposit, diff)
 	}
 }
 
 func TestTumbler_SendMixedFunds(t *testing.T) {
-	deposit := crypto.Amount("1.0")
+	deposit := crypto.MustParseAmount("1.0")
 	fundAddr := []crypto.Address{
 		0: "Genesis",
 	}
@@ -80,11 +89,11 @@ func TestTumbler_SendMixedFunds(t *testing.T) {
 		3: "House4",
 		4: "House5",
 	}
-	testTumbler := New(deposit)
+	ledger := newTestLedger(t)
+	testTumbler := New(deposit, ledger)
 	// check balance of customer address before test
 	// we expect the balance to be higher by one after the test
-	// note: this had to be relaxed to be greater than some amount because tests run concurrently
-	balance, err := crypto.CheckAddress(fundAddr[0])
+	balance, err := ledger.Balance(fundAddr[0])
 	if err != nil {
 		t.Errorf("error checking balance of address %s: %s", fundAddr, err)
 	}
@@ -93,16 +102,118 @@ func TestTumbler_SendMixedFunds(t *testing.T) {
 		t.Errorf("error mixing coins: %s", err)
 	}
 
-	newBalance, err := crypto.CheckAddress(fundAddr[0])
+	newBalance, err := ledger.Balance(fundAddr[0])
 	if err != nil {
 		t.Errorf("error checking balance of address %s: %s", fundAddr, err)
 	}
 
-	b, _ :=  strconv.ParseFloat(string(balance), 64)
-	nb, _ := strconv.ParseFloat(string(newBalance), 64)
-	diff := nb - b
+	diff := newBalance.Sub(balance)
+	if diff != deposit {
+		t.Errorf("expected difference in customer address of %s, got %s", deposit, diff)
+	}
+}
+
+func TestTumbler_PayoutShares(t *testing.T) {
+	tableTests := []struct {
+		amount      string
+		count       int
+		percentages []float64
+		expected    []string
+	}{
+		{"1", 2, []float64{50, 50}, []string{"0.5", "0.5"}},
+		// every address is guaranteed one unit, the rest is divided by the percentages
+		{"1", 3, []float64{50, 30, 20}, []string{"0.49999999", "0.3", "0.20000001"}},
+		{"0.00000002", 2, []float64{99, 1}, []string{"0.00000001", "0.00000001"}},
+		// percentages a little over 100 are scaled down rather than overdrawing the amount
+		{"0.8", 2, []float64{100.009, 0.001}, []string{"0.79999199", "0.00000801"}},
+		{"1", 2, []float64{49.995, 49.995}, []string{"0.5", "0.5"}},
+	}
 
-	if diff < 0 {
-		t.Errorf("expected difference in deposit address of atleast %f, got %f", 1.0, diff)
+	for i, tt := range tableTests {
+		t.Run(fmt.Sprintf("test %d", i), func(t *testing.T) {
+			shares, err := payoutShares(crypto.MustParseAmount(tt.amount), tt.count, tt.percentages)
+			if err != nil {
+				t.Fatalf("record %d got error %s", i, err)
+			}
+			var got []string
+			for _, share := range shares {
+				got = append(got, share.String())
+			}
+			if !reflect.DeepEqual(got, tt.expected) {
+				t.Errorf("record %d got %v, want %v", i, got, tt.expected)
+			}
+		})
+	}
+
+	// too little to give every address a unit, and percentages that do not match the addresses
+	if _, err := payoutShares(crypto.MustParseAmount("0.00000002"), 3, nil); err == nil {
+		t.Errorf("expected an error paying out 2 units to 3 addresses")
+	}
+	if _, err := payoutShares(crypto.MustParseAmount("1"), 3, []float64{50, 50}); err == nil {
+		t.Errorf("expected an error paying out with fewer percentages than addresses")
+	}
+}
+
+func TestTumbler_PlanPayoutEveryAddress(t *testing.T) {
+	customers := []crypto.Address{"Clean1", "Clean2", "Clean3", "Clean4"}
+	houses := []crypto.Address{"House1", "House2"}
+	amount := crypto.MustParseAmount("2")
+
+	for run := 0; run < 50; run++ {
+		testTumbler := New(amount, newTestLedger(t))
+		transfers, err := testTumbler.PlanPayout(context.Background(), customers, nil, houses)
+		if err != nil {
+			t.Fatalf("error planning payout: %s", err)
+		}
+
+		received := make(map[crypto.Address]crypto.Amount)
+		total := crypto.Zero
+		for _, transfer := range transfers {
+			received[transfer.To] = received[transfer.To].Add(transfer.Amount)
+			total = total.Add(transfer.Amount)
+		}
+		for _, customer := range customers {
+			if !received[customer].IsPositive() {
+				t.Fatalf("expected every address to get a share, %s got nothing from %v", customer, transfers)
+			}
+		}
+		if total != amount {
+			t.Fatalf("payout adds up to %s, want %s", total, amount)
+		}
+	}
+}
+
+func TestTumbler_ExecuteSending(t *testing.T) {
+	ledger := newTestLedger(t)
+	transfers := []Transfer{
+		{From: "House1", To: "Clean", Amount: crypto.MustParseAmount("1")},
+		{From: "House2", To: "Clean", Amount: crypto.MustParseAmount("2")},
+	}
+
+	// the first transfer went out right after it was marked, but the process stopped before recording it
+	seen, err := crypto.Seen(context.Background(), ledger, "House1")
+	if err != nil {
+		t.Fatalf("error reading history: %s", err)
+	}
+	if err := ledger.Send("House1", "Clean", transfers[0].Amount); err != nil {
+		t.Fatalf("error sending funds: %s", err)
+	}
+
+	var marks []Sending
+	testTumbler := New(crypto.MustParseAmount("3"), ledger)
+	testTumbler.Sending = &Sending{Index: 0, Seen: seen}
+	testTumbler.Mark = func(sending Sending) error {
+		marks = append(marks, sending)
+		return nil
+	}
+	if err := testTumbler.Execute(transfers, 0, nil); err != nil {
+		t.Fatalf("error executing transfers: %s", err)
+	}
+
+	if balance, _ := ledger.Balance("Clean"); balance != crypto.MustParseAmount("3") {
+		t.Errorf("expected the marked transfer not to be sent twice, Clean holds %s", balance)
+	}
+	if !reflect.DeepEqual(marks, []Sending{{Index: 1, Seen: 1}}) {
+		t.Errorf("expected only the second transfer to be marked, got %+v", marks)
 	}
 }
@@ -0,0 +1,107 @@
+package mixer
+
+import (
+	"fmt"
+	"github.com/Denton24646/gtumbler/pkg/crypto"
+	"github.com/Denton24646/gtumbler/pkg/models"
+	"math"
+	"net/http"
+	"time"
+)
+
+// maxCleanAddresses is the most clean addresses a single customer can ask the mixer to pay out to
+const maxCleanAddresses = 10
+
+// percentTolerance is how far off 100 the percentages of a request may add up to, to allow for shares like 33.33
+const percentTolerance = 0.01
+
+// maxAddressLength bounds the length of a clean address, ethereum style addresses are 42 characters
+const maxAddressLength = 64
+
+// validationError is a request the mixer refuses, along with the http status and error code to respond with
+type validationError struct {
+	status int
+	code   string
+	msg    string
+}
+
+func (v *validationError) Error() string {
+	return v.msg
+}
+
+// validateRequest checks a /create request up front, before a deposit address is handed out
+// The list of clean addresses must be non-empty, well formed, short enough and free of duplicates
+// Percentages, when given, must be positive and add up to 100 with one for every address
+func validateRequest(request *models.CleanAddressRequest) *validationError {
+	if len(request.Addresses) == 0 {
+		return &validationError{http.StatusBadRequest, models.ErrNoAddresses, "at least one clean address is required"}
+	}
+
+	if len(request.Addresses) > maxCleanAddresses {
+		return &validationError{http.StatusBadRequest, models.ErrTooManyAddresses,
+			fmt.Sprintf("at most %d clean addresses are allowed, got %d", maxCleanAddresses, len(request.Addresses))}
+	}
+
+	seen := make(map[crypto.Address]bool)
+	for _, address := range request.Addresses {
+		if !validAddress(address) {
+			return &validationError{http.StatusBadRequest, models.ErrInvalidAddress,
+				fmt.Sprintf("address %q must be 1 to %d letters, digits, '-' or '_'", address, maxAddressLength)}
+		}
+		if seen[address] {
+			return &validationError{http.StatusBadRequest, models.ErrDuplicateAddress,
+				fmt.Sprintf("address %s is listed more than once", address)}
+		}
+		seen[address] = true
+	}
+
+	if request.Percentages != nil {
+		if len(request.Percentages) != len(request.Addresses) {
+			return &validationError{http.StatusBadRequest, models.ErrInvalidPercentages,
+				fmt.Sprintf("got %d percentages for %d addresses", len(request.Percentages), len(request.Addresses))}
+		}
+		total := 0.0
+		for _, percentage := range request.Percentages {
+			if percentage <= 0 {
+				return &validationError{http.StatusBadRequest, models.ErrInvalidPercentages,
+					fmt.Sprintf("percentage %g must be positive, every address gets a share", percentage)}
+			}
+			total += percentage
+		}
+		if math.Abs(total-100) > percentTolerance {
+			return &validationError{http.StatusBadRequest, models.ErrInvalidPercentages,
+				fmt.Sprintf("percentages add up to %g instead of 100", total)}
+		}
+	}
+
+	return nil
+}
+
+// delayFor returns the window the sends of a new job are spread over, either the one the customer chose or the default
+func (m *Mixer) delayFor(request *models.CleanAddressRequest) (time.Duration, *validationError) {
+	if request.MaxDelay == nil {
+		return m.DefaultDelay, nil
+	}
+	most := int64(m.MaxDelay / time.Second)
+	if *request.MaxDelay < 0 || *request.MaxDelay > most {
+		return 0, &validationError{http.StatusBadRequest, models.ErrInvalidDelay,
+			fmt.Sprintf("maximum delay must be between 0 and %d seconds, got %d", most, *request.MaxDelay)}
+	}
+	return time.Duration(*request.MaxDelay) * time.Second, nil
+}
+
+// validAddress reports whether address is something the ledger can send to
+// JobCoin accepts any name as an address so this is deliberately looser than an ethereum address check
+func validAddress(address crypto.Address) bool {
+	if len(address) == 0 || len(address) > maxAddressLength {
+		return false
+	}
+	for _, c := range address {
+		switch {
+		case c >= 'a' && c <= 'z', c >= 'A' && c <= 'Z', c >= '0' && c <= '9', c == '-', c == '_':
+		default:
+			return false
+		}
+	}
+	return true
+}
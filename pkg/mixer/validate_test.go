@@ -0,0 +1,107 @@
+package mixer
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"github.com/Denton24646/gtumbler/pkg/crypto"
+	"github.com/Denton24646/gtumbler/pkg/models"
+	"github.com/Denton24646/gtumbler/pkg/store"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestMixer_CreateValidation(t *testing.T) {
+	testMixer := New(newTestLedger(t), store.NewMemory())
+	err := testMixer.saveCustomer(1, CustomerData{DepositAddress: "Taken", State: Completed})
+	if err != nil {
+		t.Fatalf("error saving customer: %s", err)
+	}
+
+	var tooMany []crypto.Address
+	for i := 0; i <= maxCleanAddresses; i++ {
+		tooMany = append(tooMany, crypto.Address(fmt.Sprintf("Clean%d", i)))
+	}
+
+	tableTests := []struct {
+		body   string
+		status int
+		code   string
+	}{
+		{`{"id": 2, "addresses": ["Clean1"`, http.StatusBadRequest, models.ErrInvalidJSON},
+		{`{"id": 2, "addresses": []}`, http.StatusBadRequest, models.ErrNoAddresses},
+		{`{"id": 2, "addresses": ["not an address"]}`, http.StatusBadRequest, models.ErrInvalidAddress},
+		{`{"id": 2, "addresses": ["Clean1", "Clean1"]}`, http.StatusBadRequest, models.ErrDuplicateAddress},
+		{request(2, tooMany), http.StatusBadRequest, models.ErrTooManyAddresses},
+		{`{"id": 2, "addresses": ["Clean1", "Clean2"], "percentages": [100]}`, http.StatusBadRequest, models.ErrInvalidPercentages},
+		{`{"id": 2, "addresses": ["Clean1", "Clean2"], "percentages": [100, 0]}`, http.StatusBadRequest, models.ErrInvalidPercentages},
+		{`{"id": 2, "addresses": ["Clean1", "Clean2"], "percentages": [60, 60]}`, http.StatusBadRequest, models.ErrInvalidPercentages},
+		{`{"id": 1, "addresses": ["Clean1"]}`, http.StatusConflict, models.ErrDuplicateId},
+		{`{"id": 2, "addresses": ["Clean1"], "maxDelay": -1}`, http.StatusBadRequest, models.ErrInvalidDelay},
+		{`{"id": 2, "addresses": ["Clean1"], "maxDelay": 86401}`, http.StatusBadRequest, models.ErrInvalidDelay},
+		{`{"id": 2, "addresses": ["Clean1"], "size": "60"}`, http.StatusServiceUnavailable, models.ErrInsufficientLiquidity},
+	}
+
+	for i, tt := range tableTests {
+		t.Run(fmt.Sprintf("test %d", i), func(t *testing.T) {
+			recorder := httptest.NewRecorder()
+			testMixer.Create(recorder, httptest.NewRequest(http.MethodPost, "/create", bytes.NewBufferString(tt.body)))
+			if recorder.Code != tt.status {
+				t.Errorf("record %d got status %d, want %d", i, recorder.Code, tt.status)
+			}
+
+			response := &models.ErrorResponse{}
+			if err := json.Unmarshal(recorder.Body.Bytes(), response); err != nil {
+				t.Fatalf("record %d error parsing error response: %s", i, err)
+			}
+			if response.Code != tt.code || response.Message == "" {
+				t.Errorf("record %d got error %+v, want code %s", i, response, tt.code)
+			}
+		})
+	}
+}
+
+func TestMixer_Create(t *testing.T) {
+	testMixer := New(newTestLedger(t), store.NewMemory())
+	testMixer.FeePolicy = PercentFee{Rate: 0.01}
+
+	recorder := httptest.NewRecorder()
+	maxDelay := int64(90)
+	body, _ := json.Marshal(&models.CleanAddressRequest{
+		Id:          5,
+		Addresses:   []crypto.Address{"Clean1", "Clean2"},
+		Percentages: []float64{66.67, 33.33},
+		Size:        crypto.MustParseAmount("4"),
+		MaxDelay:    &maxDelay,
+	})
+	testMixer.Create(recorder, httptest.NewRequest(http.MethodPost, "/create", bytes.NewBuffer(body)))
+	if recorder.Code != http.StatusOK {
+		t.Fatalf("expected status 200, got %d: %s", recorder.Code, recorder.Body)
+	}
+
+	response := &models.CleanAddressResponse{}
+	if err := json.Unmarshal(recorder.Body.Bytes(), response); err != nil {
+		t.Fatalf("error parsing response: %s", err)
+	}
+	customer, ok := testMixer.customer(5)
+	if !ok || customer.DepositAddress != response.DepositAddress || customer.State != AwaitingDeposit {
+		t.Errorf("expected customer 5 awaiting a deposit to %s, got %+v", response.DepositAddress, customer)
+	}
+	if response.Fee.Rate != 0.01 || response.Fee.Estimate != crypto.MustParseAmount("0.04") {
+		t.Errorf("expected a quote of 1%% estimated at 0.04, got %+v", response.Fee)
+	}
+	if !reflect.DeepEqual(customer.Percentages, []float64{66.67, 33.33}) {
+		t.Errorf("expected the requested percentages to be kept, got %v", customer.Percentages)
+	}
+	if response.MaxDelay != 90 || customer.MaxDelay != 90*time.Second {
+		t.Errorf("expected the sends to be spread over 90 seconds, got %d and %s", response.MaxDelay, customer.MaxDelay)
+	}
+}
+
+func request(id int, addresses []crypto.Address) string {
+	body, _ := json.Marshal(&models.CleanAddressRequest{Id: id, Addresses: addresses})
+	return string(body)
+}
@@ -0,0 +1,339 @@
+package mixer
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/Denton24646/gtumbler/pkg/crypto"
+	"github.com/Denton24646/gtumbler/pkg/mixer/tumbler"
+	"github.com/Denton24646/gtumbler/pkg/models"
+	"github.com/Denton24646/gtumbler/pkg/store"
+	"log"
+	"math/rand"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+)
+
+// housesBucket is the store bucket holding one json encoded House per house address
+const housesBucket = "houses"
+
+// defaultWalletSize is how many active house addresses the wallet keeps by default
+const defaultWalletSize = 5
+
+// defaultSweepInterval is how often retired house addresses are checked for coins to sweep
+const defaultSweepInterval = time.Minute
+
+// House is a house address the mixer tumbles coins through
+// Uses counts the transactions it took part in, once it reaches the limit of the wallet the address is retired:
+// it is no longer handed out, finishes the sends already planned through it and is then swept into an active house
+type House struct {
+	Address crypto.Address `json:"address"`
+	Created time.Time      `json:"created"`
+	Uses    int            `json:"uses"`
+	Retired *time.Time     `json:"retired,omitempty"`
+	Swept   *time.Time     `json:"swept,omitempty"`
+}
+
+// Wallet keeps the house addresses of the mixer, generating fresh ones as old ones are retired
+type Wallet struct {
+	// Seeds are already funded house addresses the wallet starts out with
+	Seeds []crypto.Address
+	// Size is how many active house addresses the wallet keeps at least, fresh addresses are generated to make up the number
+	Size int
+	// MaxUses is how many transactions a house address takes part in before it is retired, zero means no limit
+	MaxUses int
+
+	mu     sync.Mutex
+	houses map[crypto.Address]*House
+	ledger crypto.Ledger
+	store  store.Store
+}
+
+func NewWallet(ledger crypto.Ledger, store store.Store, seeds []crypto.Address) *Wallet {
+	return &Wallet{
+		Seeds:  seeds,
+		Size:   defaultWalletSize,
+		ledger: ledger,
+		store:  store,
+	}
+}
+
+// load reads the houses from store the first time they are needed, adopting any seed the wallet does not know yet
+func (w *Wallet) load() error {
+	if w.houses != nil {
+		return nil
+	}
+
+	records, err := w.store.List(housesBucket)
+	if err != nil {
+		return err
+	}
+	houses := make(map[crypto.Address]*House)
+	for _, record := range records {
+		house := &House{}
+		err := json.Unmarshal(record, house)
+		if err != nil {
+			return err
+		}
+		houses[house.Address] = house
+	}
+
+	now := time.Now().UTC()
+	for _, seed := range w.Seeds {
+		if _, ok := houses[seed]; ok {
+			continue
+		}
+		house := &House{Address: seed, Created: now}
+		err := w.save(house)
+		if err != nil {
+			return err
+		}
+		houses[seed] = house
+	}
+	w.houses = houses
+	return nil
+}
+
+func (w *Wallet) save(house *House) error {
+	record, err := json.Marshal(house)
+	if err != nil {
+		return err
+	}
+	return w.store.Put(housesBucket, string(house.Address), record)
+}
+
+// Active returns the house addresses new plans may use, generating fresh ones when fewer than Size are left
+func (w *Wallet) Active() ([]crypto.Address, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	err := w.load()
+	if err != nil {
+		return nil, err
+	}
+
+	active := w.active()
+	for len(active) < w.Size {
+		address, err := w.ledger.CreateAddress()
+		if err != nil {
+			return nil, err
+		}
+		house := &House{Address: address, Created: time.Now().UTC()}
+		err = w.save(house)
+		if err != nil {
+			return nil, err
+		}
+		w.houses[address] = house
+		active = append(active, address)
+		log.Printf("**** Generated house address %s", address)
+	}
+	return active, nil
+}
+
+// active returns the addresses of the houses that are not retired, oldest first
+func (w *Wallet) active() []crypto.Address {
+	var active []*House
+	for _, house := range w.houses {
+		if house.Retired == nil {
+			active = append(active, house)
+		}
+	}
+	sort.Slice(active, func(i, j int) bool {
+		if active[i].Created.Equal(active[j].Created) {
+			return active[i].Address < active[j].Address
+		}
+		return active[i].Created.Before(active[j].Created)
+	})
+
+	addresses := make([]crypto.Address, 0, len(active))
+	for _, house := range active {
+		addresses = append(addresses, house.Address)
+	}
+	return addresses
+}
+
+// Usable returns the addresses out of houses that are not retired
+func (w *Wallet) Usable(houses []crypto.Address) []crypto.Address {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	var usable []crypto.Address
+	for _, address := range houses {
+		if house, ok := w.houses[address]; ok && house.Retired == nil {
+			usable = append(usable, address)
+		}
+	}
+	return usable
+}
+
+// Remaining returns how many more transactions each active house address can take part in before it reaches MaxUses,
+// or nil when the uses are not limited
+func (w *Wallet) Remaining() (map[crypto.Address]int, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	err := w.load()
+	if err != nil || w.MaxUses == 0 {
+		return nil, err
+	}
+	remaining := make(map[crypto.Address]int)
+	for address, house := range w.houses {
+		if house.Retired != nil {
+			remaining[address] = 0
+			continue
+		}
+		remaining[address] = w.MaxUses - house.Uses
+	}
+	return remaining, nil
+}
+
+// Use counts every transfer going in or out of a house address, retiring the houses that reached MaxUses
+func (w *Wallet) Use(transfers []tumbler.Transfer) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	err := w.load()
+	if err != nil {
+		return err
+	}
+
+	used := make(map[crypto.Address]*House)
+	for _, transfer := range transfers {
+		for _, address := range []crypto.Address{transfer.From, transfer.To} {
+			house, ok := w.houses[address]
+			if !ok || house.Retired != nil {
+				continue
+			}
+			house.Uses++
+			used[address] = house
+		}
+	}
+
+	now := time.Now().UTC()
+	for _, house := range used {
+		if w.MaxUses > 0 && house.Uses >= w.MaxUses {
+			house.Retired = &now
+			log.Printf("**** Retired house address %s after %d transactions", house.Address, house.Uses)
+		}
+		err := w.save(house)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Sweep moves the coins left in retired house addresses into active ones
+// A retired house is only swept once none of the pending transfers go in or out of it
+func (w *Wallet) Sweep(ctx context.Context, pending []tumbler.Transfer) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	err := w.load()
+	if err != nil {
+		return err
+	}
+
+	busy := make(map[crypto.Address]bool)
+	for _, transfer := range pending {
+		busy[transfer.From] = true
+		busy[transfer.To] = true
+	}
+	active := w.active()
+
+	for _, house := range w.houses {
+		if house.Retired == nil || house.Swept != nil || busy[house.Address] {
+			continue
+		}
+		balance, err := w.ledger.BalanceContext(ctx, house.Address)
+		if err != nil {
+			return err
+		}
+		if balance.IsPositive() {
+			if len(active) == 0 {
+				return fmt.Errorf("no active house address to sweep %s into", house.Address)
+			}
+			to := active[rand.Intn(len(active))]
+			err := w.ledger.SendContext(ctx, house.Address, to, balance)
+			if err != nil {
+				return err
+			}
+			log.Printf("**** Swept %s coins from retired house address %s into %s", balance, house.Address, to)
+		}
+		now := time.Now().UTC()
+		house.Swept = &now
+		err = w.save(house)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Houses returns every house address the wallet ever used, oldest first
+func (w *Wallet) Houses() ([]House, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	err := w.load()
+	if err != nil {
+		return nil, err
+	}
+
+	houses := []House{}
+	for _, house := range w.houses {
+		houses = append(houses, *house)
+	}
+	sort.Slice(houses, func(i, j int) bool {
+		if houses[i].Created.Equal(houses[j].Created) {
+			return houses[i].Address < houses[j].Address
+		}
+		return houses[i].Created.Before(houses[j].Created)
+	})
+	return houses, nil
+}
+
+// WatchHouses sweeps retired house addresses every interval until ctx is done
+func (m *Mixer) WatchHouses(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			m.sweepHouses(ctx)
+		}
+	}
+}
+
+// sweepHouses sweeps the retired house addresses no job is still sending through
+// Plans are held off meanwhile so no new plan counts on coins that are being moved
+func (m *Mixer) sweepHouses(ctx context.Context) {
+	m.planMu.Lock()
+	defer m.planMu.Unlock()
+
+	err := m.Wallet.Sweep(ctx, m.pending())
+	if err != nil {
+		log.Printf("error sweeping retired house addresses: %s", err)
+	}
+}
+
+// Houses is the GET /houses endpoint for operators of the mixer - it lists every house address and how much it was used
+func (m *Mixer) Houses(w http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodGet {
+		writeError(w, http.StatusMethodNotAllowed, models.ErrMethodNotAllowed, "use GET to list the house addresses")
+		return
+	}
+
+	houses, err := m.Wallet.Houses()
+	if err != nil {
+		log.Printf("error reading house addresses: %s", err)
+		writeError(w, http.StatusInternalServerError, models.ErrInternal, "error reading house addresses")
+		return
+	}
+	writeJSON(w, http.StatusOK, houses)
+}
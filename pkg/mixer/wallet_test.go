@@ -0,0 +1,142 @@
+package mixer
+
+import (
+	"context"
+	"github.com/Denton24646/gtumbler/pkg/crypto"
+	"github.com/Denton24646/gtumbler/pkg/mixer/tumbler"
+	"github.com/Denton24646/gtumbler/pkg/store"
+	"testing"
+)
+
+func TestWallet_Active(t *testing.T) {
+	ledger := newTestLedger(t)
+	s := store.NewMemory()
+	wallet := NewWallet(ledger, s, []crypto.Address{"House1", "House2"})
+	wallet.Size = 8
+
+	active, err := wallet.Active()
+	if err != nil {
+		t.Fatalf("error listing active houses: %s", err)
+	}
+	if len(active) != 8 || active[0] != "House1" || active[1] != "House2" {
+		t.Fatalf("expected the 2 seeds topped up with 6 fresh houses, got %v", active)
+	}
+
+	// the generated houses are kept, a wallet on the same store hands out the same ones
+	reloaded, err := NewWallet(ledger, s, nil).Active()
+	if err != nil {
+		t.Fatalf("error listing active houses: %s", err)
+	}
+	if len(reloaded) != len(active) {
+		t.Errorf("expected the %d houses to be reloaded, got %v", len(active), reloaded)
+	}
+	for i := range active {
+		if i < len(reloaded) && reloaded[i] != active[i] {
+			t.Errorf("house %d reloaded as %s, want %s", i, reloaded[i], active[i])
+		}
+	}
+}
+
+func TestWallet_Rotate(t *testing.T) {
+	ledger := newTestLedger(t)
+	wallet := NewWallet(ledger, store.NewMemory(), []crypto.Address{"House1", "House2"})
+	wallet.Size = 2
+	wallet.MaxUses = 2
+
+	in := tumbler.Transfer{From: "Deposit", To: "House1", Amount: crypto.MustParseAmount("1")}
+	out := tumbler.Transfer{From: "House1", To: "Clean", Amount: crypto.MustParseAmount("1")}
+	if err := wallet.Use([]tumbler.Transfer{in}); err != nil {
+		t.Fatalf("error using houses: %s", err)
+	}
+	if usable := wallet.Usable([]crypto.Address{"House1"}); len(usable) != 1 {
+		t.Errorf("expected House1 to still be usable after 1 of 2 transactions")
+	}
+	if err := wallet.Use([]tumbler.Transfer{out}); err != nil {
+		t.Fatalf("error using houses: %s", err)
+	}
+	if usable := wallet.Usable([]crypto.Address{"House1"}); len(usable) != 0 {
+		t.Errorf("expected House1 to be retired after 2 transactions")
+	}
+
+	// a fresh house takes the place of the retired one
+	active, err := wallet.Active()
+	if err != nil {
+		t.Fatalf("error listing active houses: %s", err)
+	}
+	if len(active) != 2 || active[0] != "House2" || active[1] == "House1" {
+		t.Errorf("expected House2 and a fresh house, got %v", active)
+	}
+
+	// the retired house is only swept once nothing is pending through it
+	if err := wallet.Sweep(context.Background(), []tumbler.Transfer{out}); err != nil {
+		t.Fatalf("error sweeping: %s", err)
+	}
+	if balance, _ := ledger.Balance("House1"); balance != crypto.MustParseAmount("10") {
+		t.Errorf("expected House1 to keep its coins while a transfer is pending, got %s", balance)
+	}
+	if err := wallet.Sweep(context.Background(), nil); err != nil {
+		t.Fatalf("error sweeping: %s", err)
+	}
+	if balance, _ := ledger.Balance("House1"); !balance.IsZero() {
+		t.Errorf("expected House1 to be swept, it still holds %s", balance)
+	}
+	total := crypto.Zero
+	for _, house := range active {
+		balance, _ := ledger.Balance(house)
+		total = total.Add(balance)
+	}
+	if total != crypto.MustParseAmount("20") {
+		t.Errorf("expected the active houses to hold 20 after the sweep, got %s", total)
+	}
+
+	houses, err := wallet.Houses()
+	if err != nil {
+		t.Fatalf("error listing houses: %s", err)
+	}
+	for _, house := range houses {
+		if house.Address == "House1" && (house.Retired == nil || house.Swept == nil || house.Uses != 2) {
+			t.Errorf("expected House1 to be retired and swept after 2 uses, got %+v", house)
+		}
+	}
+}
+
+func TestMixer_PlanHouseUses(t *testing.T) {
+	ledger := newTestLedger(t)
+	testMixer := New(ledger, store.NewMemory())
+	testMixer.Pool.Interval = 0
+	testMixer.Pool.Houses = 0
+	testMixer.Wallet.MaxUses = 3
+	testMixer.Strategy = tumbler.RandomStrategy{MinChunks: 8, MaxChunks: 8}
+	// every house is one transaction short of being retired
+	var used []tumbler.Transfer
+	for _, house := range testMixer.Wallet.Seeds {
+		used = append(used, tumbler.Transfer{From: house, To: "Elsewhere"}, tumbler.Transfer{From: house, To: "Elsewhere"})
+	}
+	if err := testMixer.Wallet.Use(used); err != nil {
+		t.Fatalf("error using houses: %s", err)
+	}
+
+	customer := CustomerData{
+		CleanAddresses: []crypto.Address{"Clean"},
+		DepositAddress: "Deposit",
+		Received:       crypto.MustParseAmount("1"),
+		State:          Mixing,
+	}
+	if err := testMixer.saveCustomer(9, customer); err != nil {
+		t.Fatalf("error saving customer: %s", err)
+	}
+	if err := testMixer.plan(context.Background(), 9, &customer); err != nil {
+		t.Fatalf("error planning: %s", err)
+	}
+
+	houses, err := testMixer.Wallet.Houses()
+	if err != nil {
+		t.Fatalf("error listing houses: %s", err)
+	}
+	for _, house := range houses {
+		if house.Uses > testMixer.Wallet.MaxUses {
+			t.Errorf("expected %s to take part in at most %d transactions, got %d", house.Address, testMixer.Wallet.MaxUses,
+				house.Uses)
+		}
+	}
+}
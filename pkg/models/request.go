@@ -1,14 +1,103 @@
 package models
 
-import "github.com/Denton24646/gtumbler/pkg/crypto"
+import (
+	"github.com/Denton24646/gtumbler/pkg/crypto"
+	"time"
+)
 
 type CleanAddressRequest struct {
 	Id        int              `json:"id"`
 	Addresses []crypto.Address `json:"addresses"`
+	// Percentages optionally fixes the share of the payout each address gets, one per address and adding up to 100
+	// Without them every address still gets a share, but of a random size
+	Percentages []float64 `json:"percentages,omitempty"`
+	// Size is how much the customer plans to deposit, it is optional and used to quote the fee and to check that the
+	// house addresses can pay it out
+	Size crypto.Amount `json:"size"`
+	// MaxDelay is the longest the customer is willing to wait for the payout, in seconds
+	// The sends of the job are spread randomly over that time, when it is missing the mixer picks a default
+	MaxDelay *int64 `json:"maxDelay,omitempty"`
 }
 
 type CleanAddressResponse struct {
 	DepositAddress crypto.Address `json:"address"`
+	// Fee is the fee the mixer will charge, quoted before the customer deposits anything
+	Fee FeeQuote `json:"fee"`
+	// MaxDelay is how long in seconds the sends of the job will be spread over once the deposit arrives
+	MaxDelay int64 `json:"maxDelay"`
 }
 
+// FeeQuote is the fee a customer is charged: Flat coins plus Rate (0.005 is half a percent) of the deposit,
+// and never less than Minimum. Estimate is the resulting fee for the size the customer said it would deposit
+type FeeQuote struct {
+	Rate     float64       `json:"rate"`
+	Flat     crypto.Amount `json:"flat"`
+	Minimum  crypto.Amount `json:"minimum"`
+	Estimate crypto.Amount `json:"estimate"`
+}
+
+// StatusResponse is the state of a customer job as reported by GET /status/{id}
+type StatusResponse struct {
+	Id             int            `json:"id"`
+	State          string         `json:"state"`
+	DepositAddress crypto.Address `json:"depositAddress"`
+	// Received is the total deposited so far and PaidOut the total sent to the clean addresses so far
+	Received  crypto.Amount `json:"received"`
+	Fee       FeeQuote      `json:"fee"`
+	FeeAmount crypto.Amount `json:"feeAmount"`
+	PaidOut   crypto.Amount `json:"paidOut"`
+	// Payouts is the amount each clean address received so far
+	Payouts map[crypto.Address]crypto.Amount `json:"payouts"`
+	// NextSend is when the next transfer of the job is scheduled, it is missing when nothing is left to send
+	NextSend *time.Time `json:"nextSend,omitempty"`
+	// Refunded is the total sent back to depositors so far, Refunds lists every refund and why it was made
+	Refunded crypto.Amount  `json:"refunded"`
+	Refunds  []RefundStatus `json:"refunds"`
+	History  []StateChange  `json:"history"`
+}
 
+// RefundStatus is a deposit the mixer sends back to its sender
+type RefundStatus struct {
+	To     crypto.Address `json:"to"`
+	Amount crypto.Amount  `json:"amount"`
+	Reason string         `json:"reason"`
+	Sent   bool           `json:"sent"`
+	At     time.Time      `json:"at"`
+	Error  string         `json:"error,omitempty"`
+}
+
+// StateChange is a single move of a job into a new state
+type StateChange struct {
+	State  string    `json:"state"`
+	At     time.Time `json:"at"`
+	Reason string    `json:"reason,omitempty"`
+}
+
+// Error codes returned by the mixer in ErrorResponse.Code
+const (
+	ErrInvalidJSON           = "invalid_json"
+	ErrNoAddresses           = "no_addresses"
+	ErrInvalidAddress        = "invalid_address"
+	ErrTooManyAddresses      = "too_many_addresses"
+	ErrDuplicateAddress      = "duplicate_address"
+	ErrInvalidPercentages    = "invalid_percentages"
+	ErrDuplicateId           = "duplicate_id"
+	ErrInvalidDelay          = "invalid_delay"
+	ErrInsufficientLiquidity = "insufficient_liquidity"
+	ErrShuttingDown          = "shutting_down"
+	ErrNotFound              = "not_found"
+	ErrInvalidState          = "invalid_state"
+	ErrMethodNotAllowed      = "method_not_allowed"
+	ErrInternal              = "internal_error"
+)
+
+// ErrorResponse is the body of every failed mixer request
+// Code is meant for programs and Message for people
+type ErrorResponse struct {
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+func (e *ErrorResponse) Error() string {
+	return e.Code + ": " + e.Message
+}
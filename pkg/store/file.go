@@ -0,0 +1,116 @@
+package store
+
+import (
+	"io/ioutil"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// File is a Store kept on disk: every bucket is a directory under the root and every key is a file inside it
+// Values are written to a temporary file and renamed into place, so a crash mid-write never leaves a half written record
+type File struct {
+	mu   sync.Mutex
+	root string
+}
+
+// NewFile opens (creating it if needed) a file store rooted at the directory root
+func NewFile(root string) (*File, error) {
+	err := os.MkdirAll(root, 0700)
+	if err != nil {
+		return nil, err
+	}
+	return &File{root: root}, nil
+}
+
+func (f *File) Put(bucket string, key string, value []byte) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	dir := f.bucketPath(bucket)
+	err := os.MkdirAll(dir, 0700)
+	if err != nil {
+		return err
+	}
+
+	tmp, err := ioutil.TempFile(dir, ".tmp-")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	_, err = tmp.Write(value)
+	if err == nil {
+		err = tmp.Sync()
+	}
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return err
+	}
+
+	return os.Rename(tmp.Name(), f.keyPath(bucket, key))
+}
+
+func (f *File) Get(bucket string, key string) ([]byte, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	value, err := ioutil.ReadFile(f.keyPath(bucket, key))
+	if os.IsNotExist(err) {
+		return nil, ErrNotFound
+	}
+	return value, err
+}
+
+func (f *File) List(bucket string) (map[string][]byte, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	values := make(map[string][]byte)
+	files, err := ioutil.ReadDir(f.bucketPath(bucket))
+	if os.IsNotExist(err) {
+		return values, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	for _, file := range files {
+		if file.IsDir() || filepath.Ext(file.Name()) != ".json" {
+			continue
+		}
+		key, err := url.PathUnescape(file.Name()[:len(file.Name())-len(".json")])
+		if err != nil {
+			continue
+		}
+		value, err := ioutil.ReadFile(filepath.Join(f.bucketPath(bucket), file.Name()))
+		if err != nil {
+			return nil, err
+		}
+		values[key] = value
+	}
+	return values, nil
+}
+
+func (f *File) Delete(bucket string, key string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	err := os.Remove(f.keyPath(bucket, key))
+	if os.IsNotExist(err) {
+		return nil
+	}
+	return err
+}
+
+func (f *File) bucketPath(bucket string) string {
+	return filepath.Join(f.root, url.PathEscape(bucket))
+}
+
+// keyPath escapes the key so any string, including ones containing slashes, maps to a single file
+func (f *File) keyPath(bucket string, key string) string {
+	return filepath.Join(f.bucketPath(bucket), url.PathEscape(key)+".json")
+}
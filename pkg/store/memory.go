@@ -0,0 +1,64 @@
+package store
+
+import (
+	"sync"
+)
+
+// Memory is a Store that only lives as long as the process, it is meant for tests
+type Memory struct {
+	mu      sync.Mutex
+	buckets map[string]map[string][]byte
+}
+
+func NewMemory() *Memory {
+	return &Memory{
+		buckets: make(map[string]map[string][]byte),
+	}
+}
+
+func (m *Memory) Put(bucket string, key string, value []byte) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.buckets[bucket] == nil {
+		m.buckets[bucket] = make(map[string][]byte)
+	}
+	m.buckets[bucket][key] = copyBytes(value)
+	return nil
+}
+
+func (m *Memory) Get(bucket string, key string) ([]byte, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	value, ok := m.buckets[bucket][key]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	return copyBytes(value), nil
+}
+
+func (m *Memory) List(bucket string) (map[string][]byte, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	values := make(map[string][]byte)
+	for key, value := range m.buckets[bucket] {
+		values[key] = copyBytes(value)
+	}
+	return values, nil
+}
+
+func (m *Memory) Delete(bucket string, key string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	delete(m.buckets[bucket], key)
+	return nil
+}
+
+func copyBytes(value []byte) []byte {
+	c := make([]byte, len(value))
+	copy(c, value)
+	return c
+}
@@ -0,0 +1,22 @@
+package store
+
+import (
+	"errors"
+)
+
+// The store is a small key-value datastore used to keep mixer state across restarts
+// Values are opaque bytes (the mixer stores json) grouped into buckets, for example one bucket holds every customer record
+
+// ErrNotFound is returned by Get when the bucket does not hold the key
+var ErrNotFound = errors.New("store: key not found")
+
+type Store interface {
+	// Put creates or replaces the value stored under key in bucket
+	Put(bucket string, key string, value []byte) error
+	// Get returns the value stored under key in bucket or ErrNotFound
+	Get(bucket string, key string) ([]byte, error)
+	// List returns every key and value stored in bucket
+	List(bucket string) (map[string][]byte, error)
+	// Delete removes key from bucket, deleting a key that does not exist is not an error
+	Delete(bucket string, key string) error
+}
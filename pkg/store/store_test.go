@@ -0,0 +1,83 @@
+package store
+
+import (
+	"io/ioutil"
+	"os"
+	"reflect"
+	"testing"
+)
+
+func testStore(t *testing.T, s Store) {
+	_, err := s.Get("customers", "1")
+	if err != ErrNotFound {
+		t.Fatalf("expected ErrNotFound for a missing key, got %v", err)
+	}
+
+	values := map[string][]byte{
+		"1":   []byte(`{"id":1}`),
+		"2":   []byte(`{"id":2}`),
+		"a/b": []byte(`{"id":3}`),
+	}
+	for key, value := range values {
+		if err := s.Put("customers", key, value); err != nil {
+			t.Fatalf("error putting %s: %s", key, err)
+		}
+	}
+	if err := s.Put("other", "1", []byte("other")); err != nil {
+		t.Fatalf("error putting into other bucket: %s", err)
+	}
+
+	value, err := s.Get("customers", "a/b")
+	if err != nil {
+		t.Fatalf("error getting key: %s", err)
+	}
+	if string(value) != `{"id":3}` {
+		t.Errorf("expected %s got %s", `{"id":3}`, value)
+	}
+
+	listed, err := s.List("customers")
+	if err != nil {
+		t.Fatalf("error listing bucket: %s", err)
+	}
+	if !reflect.DeepEqual(listed, values) {
+		t.Errorf("expected %v got %v", values, listed)
+	}
+
+	if err := s.Delete("customers", "1"); err != nil {
+		t.Fatalf("error deleting key: %s", err)
+	}
+	if _, err := s.Get("customers", "1"); err != ErrNotFound {
+		t.Errorf("expected deleted key to be gone, got %v", err)
+	}
+	if err := s.Delete("customers", "1"); err != nil {
+		t.Errorf("expected deleting a missing key to succeed, got %s", err)
+	}
+}
+
+func TestStore_Memory(t *testing.T) {
+	testStore(t, NewMemory())
+}
+
+func TestStore_File(t *testing.T) {
+	dir, err := ioutil.TempDir("", "gtumbler-store")
+	if err != nil {
+		t.Fatalf("error creating temp dir: %s", err)
+	}
+	defer os.RemoveAll(dir)
+
+	s, err := NewFile(dir)
+	if err != nil {
+		t.Fatalf("error opening store: %s", err)
+	}
+	testStore(t, s)
+
+	// records survive reopening the store, which is what a mixer restart does
+	reopened, err := NewFile(dir)
+	if err != nil {
+		t.Fatalf("error reopening store: %s", err)
+	}
+	value, err := reopened.Get("customers", "2")
+	if err != nil || string(value) != `{"id":2}` {
+		t.Errorf("expected record to survive reopening the store, got %s %v", value, err)
+	}
+}